@@ -0,0 +1,210 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// maxDiffEdits is the largest number of inserted and deleted lines that
+// writeDiff shows, bigger differences are only reported.
+const maxDiffEdits = 1000
+
+// diffOp is a line of an edit script: a line kept (' '), deleted ('-')
+// or inserted ('+').
+type diffOp struct {
+	kind byte
+	a, b int // line indexes in a and b
+}
+
+// diffLines returns the shortest edit script that turns a into b, using
+// Myers' algorithm, or false if it needs more than maxEdits insertions and
+// deletions.
+func diffLines(a, b []string, maxEdits int) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > maxEdits {
+		max = maxEdits
+	}
+	off := max + 1 // v is indexed by k+off, k goes from -max-1 to max+1
+	v := make([]int, 2*max+3)
+	// trace[d] is v[-d-1..d+1] before step d, the only part of v that
+	// step d reads
+	var trace [][]int
+	found := false
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1] // move down (insertion)
+			} else {
+				x = v[off+k-1] + 1 // move right (deletion)
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				found = true
+				break search
+			}
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	// walk back through the trace to recover the edit script
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, off := trace[d], d+1
+		k := x - y
+		var prevk int
+		if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+			prevk = k + 1
+		} else {
+			prevk = k - 1
+		}
+		prevx := v[off+prevk]
+		prevy := prevx - prevk
+		for x > prevx && y > prevy {
+			x--
+			y--
+			ops = append(ops, diffOp{' ', x, y})
+		}
+		if d > 0 {
+			if x == prevx {
+				ops = append(ops, diffOp{'+', x, prevy})
+			} else {
+				ops = append(ops, diffOp{'-', prevx, y})
+			}
+		}
+		x, y = prevx, prevy
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops, true
+}
+
+// writeDiff writes the differences between a and b to w in unified diff
+// format, returns false if a and b are equal. If there are more than
+// maxDiffEdits changed lines it only says that they differ.
+func writeDiff(w io.Writer, nameA, nameB string, a, b []string) bool {
+	ops, ok := diffLines(a, b, maxDiffEdits)
+	if !ok {
+		fmt.Fprintf(w, "--- %s\n+++ %s\nFiles differ, more than %d lines changed\n", nameA, nameB, maxDiffEdits)
+		return true
+	}
+	changed := false
+	for _, op := range ops {
+		if op.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return false
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", nameA, nameB)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// extend the hunk until there are more than 2*diffContext
+		// unchanged lines between two changes
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end += diffContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		na, nb := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				na++
+			}
+			if op.kind != '-' {
+				nb++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", ops[start].a+1, na, ops[start].b+1, nb)
+		for _, op := range ops[start:end] {
+			switch op.kind {
+			case '+':
+				fmt.Fprintf(w, "+%s\n", b[op.b])
+			default:
+				fmt.Fprintf(w, "%c%s\n", op.kind, a[op.a])
+			}
+		}
+		i = end
+	}
+	return true
+}
+
+// diffInput collects the lines of one side of a diff, keeping at most max
+// bytes of text in memory and a hash of all of them.
+type diffInput struct {
+	lines    []string
+	size     int
+	max      int
+	tooLarge bool
+	hash     hash.Hash
+}
+
+func newDiffInput(max int) *diffInput {
+	return &diffInput{max: max, hash: sha1.New()}
+}
+
+// add appends line to the input, it has the signature of a forEachFileLine
+// callback.
+func (in *diffInput) add(line string) error {
+	io.WriteString(in.hash, line)
+	in.hash.Write([]byte{'\n'})
+	if in.tooLarge {
+		return nil
+	}
+	in.size += len(line) + 1
+	if in.size > in.max {
+		in.tooLarge = true
+		in.lines = nil
+		return nil
+	}
+	in.lines = append(in.lines, line)
+	return nil
+}
+
+// writeDiffInputs is writeDiff for two diffInputs, if either was too large to
+// keep in memory it only says whether they differ.
+func writeDiffInputs(w io.Writer, nameA, nameB string, a, b *diffInput) bool {
+	if bytes.Equal(a.hash.Sum(nil), b.hash.Sum(nil)) {
+		return false
+	}
+	if a.tooLarge || b.tooLarge {
+		fmt.Fprintf(w, "--- %s\n+++ %s\nFiles differ, too large to compare\n", nameA, nameB)
+		return true
+	}
+	return writeDiff(w, nameA, nameB, a.lines, b.lines)
+}
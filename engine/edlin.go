@@ -0,0 +1,1898 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+// Edlin is the state of an editing session. Term, Stdout and FS can be
+// replaced to run the editor on something other than the process' terminal
+// and file system.
+type Edlin struct {
+	Path    string
+	Lines   []string
+	Current int
+	Term    Terminal  // defaults to a TermiosTerminal on os.Stdin and os.Stdout
+	Stdout  io.Writer // defaults to Term
+	FS      FS        // defaults to OSFS
+	Dirty   bool
+
+	// Batch is set when commands come from a script instead of a user at
+	// the terminal: input is read one newline terminated line at a time,
+	// text inserted with I ends at a line containing only '.' or ^Z and
+	// nothing is echoed back.
+	Batch bool
+
+	// HistoryFile is where the commands typed at the prompt are saved
+	// between sessions, if empty they are only remembered until the end of
+	// the session.
+	HistoryFile string
+
+	// MaxBytes is the amount of text that Load and A will keep in memory,
+	// the rest of the input file stays on disk until it is appended with A
+	// or copied through by E. If zero DefaultMaxBytes is used.
+	MaxBytes int
+
+	// Recover keeps a recovery file (see RecoverPath) up to date with the
+	// changes made to the buffer, so that they can be restored by
+	// CheckRecover if the editor is killed.
+	Recover bool
+
+	// EOL is the line ending used when saving the file, Load sets it to the
+	// most common line ending of the lines it reads. It can be changed with
+	// the O command.
+	EOL EOL
+
+	// Encoding is the character encoding used to read and save the file,
+	// if it is nil Load detects it from the byte order mark, defaulting to
+	// UTF-8. It can be changed with the O command.
+	Encoding *Encoding
+
+	// ReadOnly makes the commands that save the file fail, it is set when
+	// the file is locked by another session.
+	ReadOnly bool
+
+	// NoFinalEOL and CtrlZ describe how the file ends: the last line has no
+	// line ending, the file ends with the ^Z end of file marker of DOS.
+	// They are set when the end of the input file is read and the file is
+	// saved the same way.
+	NoFinalEOL, CtrlZ bool
+
+	// Binary makes ^Z ordinary data, like the /B switch of MS-DOS edlin,
+	// otherwise the first ^Z ends the file and what follows it is ignored.
+	Binary bool
+
+	// Stdin is read by T- to insert text piped to the editor, it must be
+	// nil if standard input is a terminal or commands are read from it.
+	Stdin io.Reader
+
+	input       *bufio.Scanner // unread part of the input file
+	inputfh     io.Closer
+	inputPath   string   // path of the input file, defaults to Path
+	inputOffset int64    // bytes of the input file consumed by input
+	pushback    []string // lines given back to the input file by undo
+	inputEnc    *Encoding
+	inputSplit  *lineSplitter // splits input into lines
+	inputEOLs   eolCounts
+	eolReported bool // the user was told that inputEOLs are mixed
+
+	tempPath string // file where W writes lines, replaces Path when saving
+
+	stamp fileStamp // Path as it was when it was loaded or saved
+
+	lock     io.Closer // taken by Lock
+	lockPath string
+
+	undos, redos []journalEntry
+	pending      *journalEntry
+
+	changeCount  int       // incremented every time the buffer changes
+	recoverCount int       // value of changeCount when the recovery file was saved
+	recoverTime  time.Time // when the recovery file was saved
+
+	history       []string // commands typed at the prompt, oldest first
+	historyLoaded bool
+
+	interrupted int32 // set by Interrupt
+
+	// mu is held by Exec, Input and CheckRecover except while they wait for
+	// a key, so that EmergencySave doesn't see the buffer half changed
+	mu sync.Mutex
+
+	// lockMu protects lock and lockPath, it is separate from mu so that
+	// Unlock works even after EmergencySave
+	lockMu sync.Mutex
+
+	termMu      sync.Mutex
+	restoreTerm func() // leaves raw mode, if the terminal is in raw mode
+
+	marks  map[byte]int // line numbers of the marks set with K
+	global []int        // lines still to be visited by G and V
+
+	lastNeedle, lastReplace string
+	lastRegexp              bool
+}
+
+const DefaultMaxBytes = 64 * 1024 * 1024
+
+type ExecReturn uint8
+
+const (
+	Continue ExecReturn = iota
+	Quit
+)
+
+type MoreFn func(prompt string) (out string, ok bool)
+
+const (
+	EndOfInputFileMsg = "End of input file\n"
+)
+
+// Exec executes a command line, errors are reported to Stdout as well as
+// returned.
+func (e *Edlin) Exec(cmdstr string) (ExecReturn, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.defaults()
+	atomic.StoreInt32(&e.interrupted, 0)
+
+	defer e.updateRecover(false)
+	e.begin()
+	defer e.commit()
+
+	r, err := e.exec(cmdstr)
+	if err != nil {
+		fmt.Fprintf(e.Stdout, "%v\n", err)
+	}
+	return r, err
+}
+
+func (e *Edlin) exec(cmdstr string) (ExecReturn, error) {
+	for cmdstr != "" {
+		params, cmd, rest, err := e.parse(cmdstr)
+		if err != nil {
+			return Continue, err
+		}
+		cmdstr = ""
+
+		colonsep := func() error {
+			if len(rest) <= 0 {
+				return nil
+			}
+			if rest[0] == ';' || rest[0] == 0x1a {
+				cmdstr = rest[1:]
+				return nil
+			}
+			return ErrEntry
+		}
+
+		var name byte
+		var path, arg string
+		qmark := false
+		if cmd == '?' && len(rest) > 0 {
+			cmd = rest[0]
+			rest = rest[1:]
+			qmark = true
+		}
+
+		if cmd > 0x60 {
+			cmd = cmd & ^uint8(0x20)
+		}
+
+		if qmark && cmd != 'S' && cmd != 'R' {
+			return Continue, ErrEntry
+		}
+
+		switch cmd {
+		case 0:
+			if len(params) == 0 || (len(params) == 1 && params[0]-1 >= len(e.Lines)) {
+				return Continue, nil
+			}
+			if len(params) != 1 {
+				return Continue, ErrEntry
+			}
+		case 'Q', 'R', 'S', 'G', 'V', 'T', 'X':
+			// Q ignores the rest of the line, the others parse it themselves
+		case 'O':
+			// the option ends at the separator
+			arg = rest
+			if i := strings.IndexAny(rest, ";\x1a"); i >= 0 {
+				arg, rest = rest[:i], rest[i:]
+			} else {
+				rest = ""
+			}
+			if err := colonsep(); err != nil {
+				return Continue, err
+			}
+		case 'F':
+			// the path to save to takes the rest of the line
+			if r := strings.TrimSpace(rest); r != "" && r[0] != ';' && r[0] != 0x1a {
+				path, rest = r, ""
+			}
+			if err := colonsep(); err != nil {
+				return Continue, err
+			}
+		case 'K':
+			// the name of the mark comes before the separator
+			if len(rest) <= 0 {
+				return Continue, ErrEntry
+			}
+			name = rest[0]
+			rest = rest[1:]
+			fallthrough
+		default:
+			if err := colonsep(); err != nil {
+				return Continue, err
+			}
+		}
+
+		switch cmd {
+		case 0:
+			err = e.edit(params[0])
+		case '?':
+			if len(params) != 0 {
+				return Continue, ErrEntry
+			}
+			tw := tabwriter.NewWriter(e.Stdout, 8, 8, 4, ' ', 0)
+			fmt.Fprintf(tw, "Edit line	line#\n")
+			fmt.Fprintf(tw, "Append	[#lines]A\n")
+			fmt.Fprintf(tw, "Copy	[startline],[endline],toline[,times]C\n")
+			fmt.Fprintf(tw, "Delete	[startline][,endline]D\n")
+			fmt.Fprintf(tw, "End (save file)	E\n")
+			fmt.Fprintf(tw, "File save (as)	F[path]\n")
+			fmt.Fprintf(tw, "Global	[startline][,endline]G/text/command\n")
+			fmt.Fprintf(tw, "Global (non-matching)	[startline][,endline]V/text/command\n")
+			fmt.Fprintf(tw, "Insert	[line]I\n")
+			fmt.Fprintf(tw, "Mark	[line]Kname\n")
+			fmt.Fprintf(tw, "List	[startline][,endline]L\n")
+			fmt.Fprintf(tw, "Move	[startline],[endline],tolineM\n")
+			fmt.Fprintf(tw, "Options	O[name=value]\n")
+			fmt.Fprintf(tw, "Page	[startline][,endline]P\n")
+			fmt.Fprintf(tw, "Quit (throw away changes)	Q\n")
+			fmt.Fprintf(tw, "Redo	[#times]Y\n")
+			fmt.Fprintf(tw, "Replace	[startline][,endline][?]R[~|\\~][oldtext][CTRL+Znewtext]\n")
+			fmt.Fprintf(tw, "Search	[startline][,endline][?]S[~|\\~]text\n")
+			fmt.Fprintf(tw, "Transfer	[toline]Tpath[:startline[,endline]]\n")
+			fmt.Fprintf(tw, "Undo	[#times]U\n")
+			fmt.Fprintf(tw, "Write	[#lines]W\n")
+			fmt.Fprintf(tw, "Write lines to file	[startline][,endline]X[+]path\n")
+			tw.Flush()
+		case 'A':
+			err = e.append(params)
+		case 'C':
+			err = e.copy(params, false)
+		case 'D':
+			err = e.delete(params)
+		case 'E':
+			if err = e.end(params); err == nil {
+				return Quit, nil
+			}
+		case 'F':
+			if path != "" {
+				err = e.saveAs(params, path)
+			} else {
+				err = e.save(params)
+			}
+		case 'G', 'V':
+			var r ExecReturn
+			if r, err = e.globalCmd(params, rest, cmd == 'G'); r == Quit {
+				return Quit, nil
+			}
+		case 'I':
+			err = e.insert(params)
+		case 'K':
+			err = e.mark(params, name)
+		case 'L':
+			err = e.display(params, false)
+		case 'M':
+			err = e.copy(params, true)
+		case 'O':
+			err = e.option(params, arg)
+		case 'P':
+			err = e.display(params, true)
+		case 'Q':
+			var r ExecReturn
+			if r, err = e.quit(); r == Quit {
+				return Quit, nil
+			}
+		case 'R':
+			cmdstr, err = e.replace(params, rest, qmark)
+		case 'S':
+			cmdstr, err = e.search(params, rest, qmark)
+		case 'T':
+			err = e.transfer(params, rest)
+		case 'U':
+			if e.global != nil {
+				return Continue, ErrEntry
+			}
+			err = e.undo(params)
+		case 'W':
+			if err = e.checkSave(); err == nil {
+				err = e.write(params)
+			}
+		case 'X':
+			err = e.export(params, rest)
+		case 'Y':
+			if e.global != nil {
+				return Continue, ErrEntry
+			}
+			err = e.redo(params)
+		default:
+			return Continue, ErrEntry
+		}
+
+		if err != nil {
+			return Continue, err
+		}
+	}
+
+	return Continue, nil
+}
+
+func (e *Edlin) defaults() {
+	if e.Term == nil {
+		e.Term = NewTermiosTerminal(os.Stdin, os.Stdout)
+	}
+	if e.Stdout == nil {
+		e.Stdout = e.Term
+	}
+	if e.FS == nil {
+		e.FS = OSFS{}
+	}
+}
+
+// INPUT ////////////////////////////////////////////////////////////////////////////////////////////
+
+// Input reads a command line, it returns io.EOF at the end of the script in
+// batch mode and ErrInterrupted if the user cancels the line with Ctrl-C.
+func (e *Edlin) Input() (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.defaults()
+
+	if e.Batch {
+		return e.readScriptLine()
+	}
+
+	e.loadHistory()
+
+	rr := e.newRawReader()
+	defer rr.Close()
+
+	le := &lineEditor{out: e.Stdout}
+
+	// hi is the position in history of the line being edited, typed is the
+	// line that was being typed before moving through history
+	hi := len(e.history)
+	var typed []rune
+
+	for {
+		buf, err := rr.Next()
+		if err != nil {
+			return "", err
+		}
+		if r, ok := keyRune(buf); ok {
+			switch r {
+			case 0x3: // Ctrl-C
+				le.end()
+				fmt.Fprintf(e.Stdout, "^C")
+				return "", ErrInterrupted
+			case 0x7f: // Backspace
+				le.backspace()
+			case 0xd: // Return
+				le.end()
+				e.addHistory(le.String())
+				return le.String(), nil
+			default:
+				le.insert(r)
+			}
+			continue
+		}
+
+		switch {
+		case bytes.Equal(buf, escSeqUp):
+			if hi > 0 {
+				if hi == len(e.history) {
+					typed = append([]rune{}, le.buf...)
+				}
+				hi--
+				le.set([]rune(e.history[hi]))
+			}
+		case bytes.Equal(buf, escSeqDown):
+			if hi < len(e.history) {
+				hi++
+				if hi == len(e.history) {
+					le.set(typed)
+				} else {
+					le.set([]rune(e.history[hi]))
+				}
+			}
+		case bytes.Equal(buf, escSeqF3):
+			// copy the rest of the previous command, as in the line editor
+			if len(e.history) > 0 {
+				prev := []rune(e.history[len(e.history)-1])
+				le.end()
+				for i := len(le.buf); i < len(prev); i++ {
+					le.insert(prev[i])
+				}
+			}
+		default:
+			le.key(buf)
+		}
+	}
+}
+
+// readScriptLine reads a newline terminated line in batch mode.
+func (e *Edlin) readScriptLine() (string, error) {
+	line, err := e.readTermLine()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+func (e *Edlin) parse(cmdstr string) (params []int, cmd byte, rest string, err error) {
+	// Syntax:
+	// cmd ::= <params> <cmdbyte>
+	// params ::= <param> | <param> [' '] ',' <params>
+	// param ::= '%' | <line> <offset>* | <offset>+
+	// line ::= '.' | '#' | '$' | <number> | <search> | '\'' <name>
+	// search ::= '/' <text> '/' | '\\' <text> '\\'
+	// offset ::= '+' <number> | '-' <number>
+	//
+	// '$' is the last line, '#' the line after it and '%' is the same as
+	// "1,#". '%' is only valid as the first parameter. Offsets without a
+	// line are relative to the current line.
+	// 'name is the line of a mark set with K.
+	// A search finds the next (/) or previous (\) line containing text,
+	// starting from the current line or, after the first parameter, from the
+	// line of the preceding parameter.
+	// A plain number can be past the end of the buffer, any other parameter
+	// must be between 1 and '#'.
+
+	params = make([]int, 0, 4)
+	base := e.Current
+
+	for i := 0; i < len(cmdstr); {
+		readnum := func() int {
+			n := 0
+			for i < len(cmdstr) && cmdstr[i] >= '0' && cmdstr[i] <= '9' {
+				n *= 10
+				n += int(cmdstr[i] - '0')
+				i++
+			}
+			return n
+		}
+
+		added := true
+		start := i
+		n, plain := 0, false
+
+		switch cmdstr[i] {
+		case '%':
+			if len(params) != 0 {
+				// it stands for two parameters, only the first can be one
+				return nil, 0, "", ErrEntry
+			}
+			i++
+			params = append(params, 1)
+			n = len(e.Lines) + 1
+		case '.':
+			i++
+			n = e.Current
+		case '#':
+			i++
+			n = len(e.Lines) + 1
+		case '$':
+			i++
+			n = len(e.Lines)
+		case '+', '-':
+			n = e.Current
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			n = readnum()
+			if n <= 0 {
+				return nil, 0, "", ErrEntry
+			}
+			plain = true
+		case '\'':
+			if i+1 >= len(cmdstr) {
+				return nil, 0, "", ErrEntry
+			}
+			var ok bool
+			n, ok = e.marks[markName(cmdstr[i+1])]
+			if !ok {
+				return nil, 0, "", &MarkError{cmdstr[i+1]}
+			}
+			i += 2
+		case '/', '\\':
+			delim := cmdstr[i]
+			end := strings.IndexByte(cmdstr[i+1:], delim)
+			if end < 0 {
+				return nil, 0, "", ErrEntry
+			}
+			needle := cmdstr[i+1 : i+1+end]
+			i += end + 2
+			n, err = e.searchAddress(needle, base, delim == '/')
+			if err != nil {
+				return nil, 0, "", err
+			}
+		default:
+			added = false
+		}
+
+		if added {
+			if cmdstr[start] != '%' {
+				for i < len(cmdstr) && (cmdstr[i] == '+' || cmdstr[i] == '-') {
+					sign := 1
+					if cmdstr[i] == '-' {
+						sign = -1
+					}
+					i++
+					n += sign * readnum()
+					plain = false
+				}
+			}
+			if !plain && (n < 1 || n > len(e.Lines)+1) {
+				return nil, 0, "", &RangeError{cmdstr[start:i], n}
+			}
+			params = append(params, n)
+			base = n
+		}
+
+		if i >= len(cmdstr) {
+			return params, 0, "", nil
+		}
+
+		if cmdstr[i] == ' ' {
+			i++
+			if i >= len(cmdstr) {
+				return params, 0, "", nil
+			}
+		}
+		if cmdstr[i] != ',' {
+			return params, cmdstr[i], cmdstr[i+1:], nil
+		}
+		i++
+
+		if !added {
+			params = append(params, 0)
+		}
+
+		if len(params) >= 4 {
+			return nil, 0, "", ErrEntry
+		}
+	}
+
+	return nil, 0, "", ErrEntry
+}
+
+// readFileLines reads all the lines of fh, split by ls, and closes it.
+func readFileLines(fh io.ReadCloser, path string, ls *lineSplitter) ([]string, error) {
+	return readLineRange(fh, path, ls, 1, 0)
+}
+
+// errStop stops forEachFileLine early.
+var errStop = errors.New("stop")
+
+// readLineRange is readFileLines for lines start to end, counting from 1, if
+// end is zero up to the end of the file.
+func readLineRange(fh io.ReadCloser, path string, ls *lineSplitter, start, end int) ([]string, error) {
+	r := []string{}
+	n := 0
+	err := forEachFileLine(fh, path, ls, func(line string) error {
+		n++
+		if end > 0 && n > end {
+			return errStop
+		}
+		if n >= start {
+			r = append(r, line)
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return nil, err
+	}
+	return r, nil
+}
+
+// forEachFileLine calls fn for each line of fh, like readFileLines, without
+// keeping them in memory.
+func forEachFileLine(fh io.ReadCloser, path string, ls *lineSplitter, fn func(string) error) error {
+	defer fh.Close()
+	rd := newLineScanner(fh)
+	rd.Split(ls.split)
+	for rd.Scan() {
+		if err := fn(ls.enc.decode(rd.Bytes())); err != nil {
+			return err
+		}
+	}
+	if err := rd.Err(); err != nil {
+		return &IOError{"read", path, err}
+	}
+	return nil
+}
+
+// maxLineLength is the length of the longest line that can be read, i.e.
+// lines are only limited by the available memory.
+const maxLineLength = int(^uint(0) >> 1)
+
+// newLineScanner returns a bufio.Scanner reading lines of any length from rd.
+func newLineScanner(rd io.Reader) *bufio.Scanner {
+	sc := bufio.NewScanner(rd)
+	sc.Buffer(nil, maxLineLength)
+	return sc
+}
+
+// maxBytes returns MaxBytes or its default.
+func (e *Edlin) maxBytes() int {
+	if e.MaxBytes <= 0 {
+		return DefaultMaxBytes
+	}
+	return e.MaxBytes
+}
+
+// Load starts editing the contents of fh, lines are read until MaxBytes of
+// text are in memory, the rest of the file is left to the A command.
+// If Encoding is nil it is detected from the byte order mark of the file.
+func (e *Edlin) Load(fh io.ReadCloser) error {
+	e.defaults()
+	if e.inputPath == "" {
+		e.inputPath = e.Path
+	}
+	e.stamp, _ = e.stampFile(e.Path)
+	rd := bufio.NewReader(fh)
+	enc, n := readBOM(rd, e.Encoding)
+	e.Encoding = enc
+	e.setInput(fh, rd, int64(n), enc)
+	e.inputEOLs = eolCounts{}
+	e.eolReported = false
+	e.NoFinalEOL, e.CtrlZ = false, false
+	eof, err := e.appendLines(0)
+	if eol, ok := e.inputEOLs.most(); ok {
+		e.EOL = eol
+	}
+	e.reportEOLs()
+	if eof {
+		fmt.Fprintf(e.Stdout, EndOfInputFileMsg)
+	}
+	return err
+}
+
+// appendLines reads n lines from the input file into e.Lines, if n is zero
+// reads lines until MaxBytes of text are in memory. Returns true if the end
+// of the input file was reached.
+func (e *Edlin) appendLines(n int) (eof bool, err error) {
+	max := e.maxBytes()
+	size := 0
+	if n <= 0 {
+		for _, line := range e.Lines {
+			size += len(line) + 1
+		}
+	}
+	temp := []string{}
+	for i := 0; n <= 0 || i < n; i++ {
+		if n <= 0 && size >= max {
+			break
+		}
+		if err = e.checkInterrupt(); err != nil {
+			break
+		}
+		var line string
+		var ok bool
+		line, ok, err = e.readLine()
+		if !ok {
+			eof = err == nil
+			break
+		}
+		temp = append(temp, line)
+		size += len(line) + 1
+	}
+	if len(temp) > 0 {
+		// lines are appended to the buffer by taking them back from pushback
+		e.pushback = append(temp, e.pushback...)
+		_ = e.do(change{read: len(temp)})
+	}
+	return eof, err
+}
+
+// readLine returns the next line of the input file.
+func (e *Edlin) readLine() (string, bool, error) {
+	if len(e.pushback) > 0 {
+		line := e.pushback[0]
+		e.pushback = e.pushback[1:]
+		return line, true, nil
+	}
+	if e.input == nil {
+		return "", false, nil
+	}
+	if !e.input.Scan() {
+		err := e.input.Err()
+		e.closeInput()
+		if err != nil {
+			return "", false, &IOError{"read", e.Path, err}
+		}
+		e.inputEnded(e.inputSplit)
+		return "", false, nil
+	}
+	return e.inputEnc.decode(e.input.Bytes()), true, nil
+}
+
+// inputEnded records how the input file ends, ls split it up to the end.
+func (e *Edlin) inputEnded(ls *lineSplitter) {
+	e.NoFinalEOL, e.CtrlZ = ls.noEOL, ls.ctrlZ
+	if ls.ignored {
+		fmt.Fprintf(e.Stdout, "Text after the end of file marker (^Z) ignored\n")
+	}
+}
+
+// scanLines splits the lines of the input file, keeping track of how much of
+// it was read and of how it ends.
+func (e *Edlin) scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := e.inputSplit.split(data, atEOF)
+	e.inputOffset += int64(advance)
+	return advance, token, err
+}
+
+// setInput starts reading the input file, encoded in enc, from rd which
+// reads fh from offset.
+func (e *Edlin) setInput(fh io.ReadCloser, rd io.Reader, offset int64, enc *Encoding) {
+	e.input = newLineScanner(rd)
+	e.input.Split(e.scanLines)
+	e.inputfh = fh
+	e.inputOffset = offset
+	e.inputEnc = enc
+	e.inputSplit = newLineSplitter(enc, !e.Binary)
+	e.inputSplit.counts = &e.inputEOLs
+}
+
+func (e *Edlin) closeInput() {
+	if e.input == nil {
+		return
+	}
+	e.inputfh.Close()
+	e.input = nil
+	e.inputfh = nil
+}
+
+// COMMANDS ////////////////////////////////////////////////////////////////////////////////////////////
+
+func (e *Edlin) append(params []int) error {
+	n, err := params1(params)
+	if err != nil {
+		return err
+	}
+	eof, err := e.appendLines(n)
+	e.reportEOLs()
+	if eof {
+		fmt.Fprintf(e.Stdout, EndOfInputFileMsg)
+	}
+	return err
+}
+
+func (e *Edlin) copy(params []int, move bool) error {
+	if len(params) < 3 {
+		return ErrEntry
+	}
+	if move && len(params) != 3 {
+		return ErrEntry
+	}
+	times := 1
+	if len(params) > 3 {
+		times = params[3]
+		if times <= 0 {
+			return ErrEntry
+		}
+	}
+
+	p0 := params[0]
+	p1 := params[1]
+	p2 := params[2]
+	if p0 == 0 {
+		p0 = e.Current
+	}
+	if p1 == 0 {
+		p1 = e.Current
+	}
+	if p0 > p1 || p0 < 0 || p0 > len(e.Lines) || p1 > len(e.Lines) || p2 <= 0 || p2 > len(e.Lines)+1 || (p2 >= p0 && p2 <= p1) {
+		return ErrEntry
+	}
+
+	temp := make([]string, p1-p0+1)
+	copy(temp, e.Lines[p0-1:p1])
+
+	if move {
+		// marks move with their lines
+		moved := map[byte]int{}
+		for name, line := range e.marks {
+			if line >= p0 && line <= p1 {
+				moved[name] = line - p0
+			}
+		}
+		e.replaceLines(p0, len(temp), nil)
+		if p2 >= p1 {
+			p2 -= (p1 - p0) + 1
+		}
+		for name := range moved {
+			moved[name] += p2
+		}
+		e.Current = p2
+		_ = e.do(change{line: p2, new: temp, marks: moved})
+		return nil
+	}
+
+	e.copyIntl(temp, times, p2)
+	return nil
+}
+
+func (e *Edlin) copyIntl(temp []string, times int, p2 int) {
+	e.Current = p2
+
+	if len(temp) == 0 {
+		return
+	}
+
+	extra := make([]string, 0, len(temp)*times)
+	for t := 0; t < times; t++ {
+		extra = append(extra, temp...)
+	}
+	e.replaceLines(p2, 0, extra)
+}
+
+func (e *Edlin) edit(p0 int) error {
+	e.Current = p0
+
+	if e.Current > len(e.Lines) || e.Current <= 0 {
+		return nil
+	}
+
+	if e.Batch {
+		line, err := e.readScriptLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if line != e.Lines[e.Current-1] {
+			e.replaceLines(e.Current, 1, []string{line})
+		}
+		return nil
+	}
+
+	e.printLine(e.Current, '*', e.Lines[e.Current-1])
+	fmt.Fprintf(e.Stdout, "%7d:*", e.Current)
+
+	rr := e.newRawReader()
+	defer rr.Close()
+
+	// model is the template for the new line, mi the next character of the
+	// template that will be copied by F1 and Right, typing in overwrite
+	// mode or Delete at the end of the line skip it.
+	model := []rune(e.Lines[e.Current-1])
+	mi := 0
+
+	le := &lineEditor{out: e.Stdout, overwrite: true}
+	ok := true
+
+	emit := func(r rune) {
+		atEnd := le.pos == len(le.buf)
+		le.insert(r)
+		if le.overwrite && atEnd {
+			mi++
+		}
+	}
+
+	emitModel := func() {
+		if mi >= len(model) {
+			return
+		}
+		le.insert(model[mi])
+	}
+
+editLoop:
+	for {
+		buf, err := rr.Next()
+		if err != nil {
+			return err
+		}
+
+		r, isRune := keyRune(buf)
+		switch {
+		case isRune:
+			switch r {
+			case 0x3: // Ctrl-C
+				ok = false
+				le.end()
+				fmt.Fprintf(e.Stdout, "^C")
+				break editLoop
+			case 0x1a: // Ctrl-Z
+				ok = false
+				le.end()
+				fmt.Fprintf(e.Stdout, "^Z")
+			case 0x7f: // Backspace
+				le.backspace()
+			case 0xd: // Return
+				le.end()
+				break editLoop
+			default:
+				emit(r)
+			}
+
+		case bytes.Equal(buf, escSeqDelete):
+			// delete the character under the cursor, at the end of the line
+			// skip a single character from model
+			if !le.del() {
+				mi++
+			}
+		case bytes.Equal(buf, escSeqRight):
+			// move right, at the end of the line copy a single character
+			// from model
+			if !le.right() {
+				emitModel()
+				mi++
+			}
+		case bytes.Equal(buf, escSeqF1):
+			// copy a single character from model
+			emitModel()
+			mi++
+		case bytes.Equal(buf, escSeqF3):
+			// copy everything from model till the end of the line
+			for mi < len(model) {
+				emitModel()
+				mi++
+			}
+		case bytes.Equal(buf, escSeqF5):
+			// copy current input to model, display a @ to signify that the model was copied
+			le.end()
+			fmt.Fprintf(e.Stdout, "@")
+			model = le.buf
+			le.reset()
+			mi = 0
+
+		case bytes.Equal(buf, escSeqF2):
+			// copy everythin from model till the first match of the argument character
+			arg, err := rr.Next()
+			if err != nil {
+				return err
+			}
+			target, _ := keyRune(arg)
+			for mi < len(model) {
+				if model[mi] == target {
+					break
+				}
+				emitModel()
+				mi++
+			}
+
+		case bytes.Equal(buf, escSeqF4):
+			//skip everything on model till the first match of the argument character
+			arg, err := rr.Next()
+			if err != nil {
+				return err
+			}
+			target, _ := keyRune(arg)
+			for mi < len(model) {
+				if model[mi] == target {
+					break
+				}
+				mi++
+			}
+
+		default:
+			// Left, Home, End and Insert
+			le.key(buf)
+		}
+	}
+
+	if ok && le.String() != e.Lines[e.Current-1] {
+		e.replaceLines(e.Current, 1, []string{le.String()})
+	}
+	return nil
+}
+
+func (e *Edlin) delete(params []int) error {
+	// two parameters, zero means e.Current for the first and the first for
+	// the second
+	// deletes the interval specified, moves e.Current to the first line after the interval
+	p0, p1, err := params2(params)
+	if err != nil {
+		return err
+	}
+	if p0 == 0 {
+		p0 = e.Current
+	}
+	if p1 == 0 {
+		p1 = p0
+	}
+	if p1 == len(e.Lines)+1 && p0 < p1 {
+		// "1,#" means the whole buffer
+		p1 = len(e.Lines)
+	}
+	if p0 > p1 || p0 < 0 || p0 > len(e.Lines) || p1 > len(e.Lines) {
+		return ErrEntry
+	}
+
+	e.replaceLines(p0, p1-p0+1, nil)
+	e.Current = p0
+	return nil
+}
+
+// globalCmd runs command on every line in the range that contains text (or
+// that doesn't, if match is false), with the current line set to it.
+// The range defaults to the whole buffer, the command to listing the line.
+func (e *Edlin) globalCmd(params []int, rest string, match bool) (ExecReturn, error) {
+	if e.global != nil {
+		return Continue, ErrEntry
+	}
+	p0, p1, err := params2(params)
+	if err != nil {
+		return Continue, err
+	}
+	if p0 == 0 {
+		p0 = 1
+	}
+	if p1 == 0 || p1 > len(e.Lines) {
+		p1 = len(e.Lines)
+	}
+	if len(rest) <= 0 {
+		return Continue, ErrEntry
+	}
+	delim := rest[0]
+	end := strings.IndexByte(rest[1:], delim)
+	if end < 0 {
+		return Continue, ErrEntry
+	}
+	needle, command := rest[1:end+1], rest[end+2:]
+	if command == "" {
+		command = ".,.L"
+	}
+	m, err := e.lineMatcher(needle)
+	if err != nil {
+		return Continue, err
+	}
+
+	e.global = []int{}
+	defer func() {
+		e.global = nil
+	}()
+	for i := p0; i <= p1; i++ {
+		if m(e.Lines[i-1]) == match {
+			e.global = append(e.global, i)
+		}
+	}
+	for i := range e.global {
+		// lines deleted by the previous commands are set to zero by spliceLines
+		if e.global[i] == 0 {
+			continue
+		}
+		if err := e.checkInterrupt(); err != nil {
+			return Continue, err
+		}
+		e.Current = e.global[i]
+		if r, err := e.exec(command); err != nil || r == Quit {
+			return r, err
+		}
+	}
+	return Continue, nil
+}
+
+func (e *Edlin) insert(params []int) error {
+	p0, err := params1(params)
+	if err != nil {
+		return err
+	}
+	if p0 == 0 {
+		p0 = e.Current
+	}
+	if p0 == 0 {
+		p0 = 1
+	}
+	if p0 > len(e.Lines)+1 {
+		return ErrEntry
+	}
+	temp := []string{}
+
+	for {
+		ln, cont, err := e.insertOne(p0 + len(temp))
+		if err != nil {
+			return err
+		}
+		if !cont {
+			break
+		}
+		temp = append(temp, ln)
+	}
+
+	e.copyIntl(temp, 1, p0)
+	e.Current += len(temp)
+	return nil
+}
+
+func (e *Edlin) insertOne(idx int) (string, bool, error) {
+	if e.Batch {
+		line, err := e.readScriptLine()
+		if err == io.EOF || line == "." || line == "\x1a" {
+			return "", false, nil
+		}
+		return line, err == nil, err
+	}
+
+	fmt.Fprintf(e.Stdout, "%7d:*", idx)
+
+	rr := e.newRawReader()
+	defer rr.Close()
+
+	le := &lineEditor{out: e.Stdout}
+
+	for {
+		buf, err := rr.Next()
+		if err != nil {
+			return "", false, err
+		}
+
+		if r, ok := keyRune(buf); ok {
+			switch r {
+			case 0x3: // Ctrl-C
+				le.end()
+				fmt.Fprintf(e.Stdout, "^C")
+				return "", false, nil
+
+			case 0x7f: // Backspace
+				le.backspace()
+			case 0xd: // Return
+				le.end()
+				return le.String(), true, nil
+			default:
+				le.insert(r)
+			}
+		} else {
+			le.key(buf)
+		}
+	}
+}
+
+func (e *Edlin) end(params []int) error {
+	if err := e.save(params); err != nil {
+		return err
+	}
+	e.closeInput()
+	e.removeTemp()
+	return nil
+}
+
+func (e *Edlin) display(params []int, setcur bool) error {
+	p0, p1, err := params2(params)
+	if err != nil {
+		return err
+	}
+
+	page := 23
+	if _, rows, err := e.Term.Size(); err == nil && rows > 1 {
+		page = rows - 1
+	}
+
+	start := e.Current - page/2
+	if start <= 0 {
+		start = 1
+	}
+	n := page
+
+	if p0 != 0 {
+		start = p0
+	}
+	if p1 != 0 {
+		n = p1 - start + 1
+	}
+
+	if n <= 0 {
+		n = page
+	}
+
+	if setcur {
+		e.Current = 0
+	}
+
+	for i := 0; i < n && (i+start-1 < len(e.Lines)); i++ {
+		if err := e.checkInterrupt(); err != nil {
+			return err
+		}
+		last := !(i+1 < n && (i+start < len(e.Lines)))
+		if last && setcur {
+			e.Current = i + start
+		}
+		iscur := ' '
+		if i+start == e.Current {
+			iscur = '*'
+		}
+		e.printLine(i+start, iscur, e.Lines[i+start-1])
+	}
+	return nil
+}
+
+// clipIndicator is appended to lines cut short by printLine, with the
+// number of characters left out.
+const clipIndicator = "... (%d more characters)"
+
+// printLine displays line n, marked with iscur, lines too long to fit on the
+// screen are cut short. If the size of the screen isn't known, for example
+// because the output isn't a terminal, lines are displayed whole.
+func (e *Edlin) printLine(n int, iscur rune, s string) {
+	cols, rows, err := e.Term.Size()
+	if err != nil || cols <= 0 || rows <= 1 {
+		fmt.Fprintf(e.Stdout, "%7d:%c%s\n", n, iscur, s)
+		return
+	}
+	// the line can fill the page, less the line number, when it doesn't
+	// it's cut where there's still room for the indicator
+	max := cols*(rows-1) - len("1234567:*")
+	room := max - len(clipIndicator) - 10
+	cut, w := -1, 0
+	for i, r := range s {
+		w += runeWidth(r)
+		if cut < 0 && w > room {
+			cut = i
+		}
+		if w > max {
+			s = s[:cut] + fmt.Sprintf(clipIndicator, utf8.RuneCountInString(s[cut:]))
+			break
+		}
+	}
+	fmt.Fprintf(e.Stdout, "%7d:%c%s\n", n, iscur, s)
+}
+
+func (e *Edlin) mark(params []int, name byte) error {
+	p0, err := params1(params)
+	if err != nil {
+		return err
+	}
+	if p0 == 0 {
+		p0 = e.Current
+	}
+	if p0 > len(e.Lines) {
+		return ErrEntry
+	}
+	name = markName(name)
+	if name < 'a' || name > 'z' {
+		return ErrEntry
+	}
+	if e.marks == nil {
+		e.marks = make(map[byte]int)
+	}
+	e.marks[name] = p0
+	return nil
+}
+
+// markName returns the canonical (lower case) name of a mark.
+func markName(name byte) byte {
+	if name >= 'A' && name <= 'Z' {
+		name += 'a' - 'A'
+	}
+	return name
+}
+
+// option shows the options of the buffer or, if arg is name=value, sets one.
+// The options are eol, the line ending used to save the file (LF, CRLF or
+// CR), and encoding, its character encoding.
+func (e *Edlin) option(params []int, arg string) error {
+	if len(params) != 0 {
+		return ErrEntry
+	}
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		fmt.Fprintf(e.Stdout, "eol=%v\nencoding=%v\n", e.EOL, e.Encoding)
+		return nil
+	}
+	eq := strings.IndexByte(arg, '=')
+	if eq < 0 {
+		return ErrEntry
+	}
+	name, value := strings.TrimSpace(arg[:eq]), strings.TrimSpace(arg[eq+1:])
+	c := change{format: true, oldEOL: e.EOL, newEOL: e.EOL, oldEnc: e.Encoding, newEnc: e.Encoding}
+	var ok bool
+	switch strings.ToLower(name) {
+	case "eol":
+		c.newEOL, ok = parseEOL(value)
+	case "encoding":
+		c.newEnc, ok = LookupEncoding(value)
+	}
+	if !ok {
+		return ErrEntry
+	}
+	if c.newEOL == c.oldEOL && c.newEnc == c.oldEnc {
+		return nil
+	}
+	// changing the format can be undone like any other change
+	return e.do(c)
+}
+
+func (e *Edlin) quit() (ExecReturn, error) {
+	if !e.Dirty {
+		e.removeTemp()
+		return Quit, nil
+	}
+
+	key, err := e.yesno("Abort edit (Y/N)? ", true)
+	if err != nil {
+		return Continue, err
+	}
+	switch key {
+	case 'Y':
+		e.closeInput()
+		e.removeTemp()
+		e.removeRecover()
+		return Quit, nil
+	default:
+		return Continue, nil
+	}
+
+}
+
+func (e *Edlin) replace(params []int, needleAndRepl string, qmark bool) (rest string, err error) {
+	p0, p1, err := params2(params)
+	if err != nil {
+		return "", err
+	}
+	if p0 == 0 {
+		p0 = e.Current + 1
+	}
+	if p1 == 0 {
+		p1 = len(e.Lines) + 1
+	}
+	var needle, replace string
+	needleAndRepl, regex := regexPrefix(needleAndRepl)
+	if needleAndRepl == "" {
+		needle = e.lastNeedle
+		replace = e.lastReplace
+		regex = regex || e.lastRegexp
+	} else {
+		ctrlz := strings.Index(needleAndRepl, "\x1a")
+		if ctrlz < 0 {
+			needle = needleAndRepl
+			replace = ""
+		} else {
+			needle = needleAndRepl[:ctrlz]
+			replace = needleAndRepl[ctrlz+1:]
+			if ctrlz := strings.Index(replace, "\x1a"); ctrlz >= 0 {
+				rest = replace[ctrlz+1:]
+				replace = replace[:ctrlz]
+			}
+		}
+	}
+	if needle == "" {
+		// every position matches, in both modes
+		return "", ErrEntry
+	}
+	e.lastNeedle = needle
+	e.lastReplace = replace
+	e.lastRegexp = regex
+
+	var re *regexp.Regexp
+	if regex {
+		re, err = compileRegexp(needle)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for i := p0; i <= len(e.Lines) && i <= p1; i++ {
+		if err := e.checkInterrupt(); err != nil {
+			return "", err
+		}
+		s := e.Lines[i-1]
+		changed := false
+		if re != nil {
+			s, changed, err = e.replaceRegexp(i, s, re, replace, qmark)
+			if err != nil {
+				return "", err
+			}
+		}
+		z := 0
+		for re == nil {
+			// a line can have many matches, or prompt for each of them
+			if err = e.checkInterrupt(); err != nil {
+				break
+			}
+			o := strings.Index(s[z:], needle)
+			if o < 0 {
+				break
+			}
+			z += o
+
+			iscur := ' '
+			if i == e.Current {
+				iscur = '*'
+			}
+
+			doit := true
+			if qmark {
+				e.printLine(i, iscur, s)
+				key, err := e.yesno("O.K.? ", false)
+				if err != nil {
+					return "", err
+				}
+				doit = key == 'Y'
+			}
+			if !doit {
+				z += len(needle)
+				continue
+			}
+
+			s = s[:z] + replace + s[z+len(needle):]
+			z += len(replace)
+			e.Current = i
+			changed = true
+
+			if !qmark {
+				e.printLine(i, iscur, s)
+			}
+		}
+		if changed {
+			e.replaceLines(i, 1, []string{s})
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return rest, nil
+}
+
+// replaceRegexp replaces each match of re in s, which is line i, with the
+// expansion of replace.
+func (e *Edlin) replaceRegexp(i int, s string, re *regexp.Regexp, replace string, qmark bool) (string, bool, error) {
+	changed := false
+	out := []byte{}
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		iscur := ' '
+		if i == e.Current {
+			iscur = '*'
+		}
+
+		doit := true
+		if qmark {
+			e.printLine(i, iscur, string(out)+s[last:])
+			key, err := e.yesno("O.K.? ", false)
+			if err != nil {
+				return "", false, err
+			}
+			doit = key == 'Y'
+		}
+		if !doit {
+			out = append(out, s[last:m[1]]...)
+			last = m[1]
+			continue
+		}
+
+		out = append(out, s[last:m[0]]...)
+		out = re.ExpandString(out, replace, s, m)
+		last = m[1]
+		e.Current = i
+		changed = true
+
+		if !qmark {
+			e.printLine(i, iscur, string(out)+s[last:])
+		}
+	}
+	return string(out) + s[last:], changed, nil
+}
+
+func (e *Edlin) search(params []int, needle string, qmark bool) (rest string, err error) {
+	p0, p1, err := params2(params)
+	if err != nil {
+		return "", err
+	}
+	if p0 == 0 {
+		p0 = e.Current + 1
+	}
+	if p1 == 0 {
+		p1 = len(e.Lines) + 1
+	}
+	if ctrlz := strings.Index(needle, "\x1a"); ctrlz >= 0 {
+		rest = needle[ctrlz+1:]
+		needle = needle[:ctrlz]
+	}
+	match, err := e.lineMatcher(needle)
+	if err != nil {
+		return "", err
+	}
+
+	for i := p0; i <= len(e.Lines) && i <= p1; i++ {
+		if err := e.checkInterrupt(); err != nil {
+			return "", err
+		}
+		if !match(e.Lines[i-1]) {
+			continue
+		}
+		iscur := ' '
+		if i == e.Current {
+			iscur = '*'
+		}
+		e.printLine(i, iscur, e.Lines[i-1])
+		if !qmark {
+			e.Current = i
+			return "", nil
+		}
+		key, err := e.yesno("O.K.? ", false)
+		if err != nil {
+			return "", err
+		}
+		if key == 'Y' {
+			e.Current = i
+			return "", nil
+		}
+	}
+
+	return rest, ErrNotFound
+}
+
+// searchAddress returns the first line after (or before, if forward is
+// false) line from that contains needle, wrapping around the end (or start)
+// of the buffer.
+func (e *Edlin) searchAddress(needle string, from int, forward bool) (int, error) {
+	match, err := e.lineMatcher(needle)
+	if err != nil {
+		return 0, err
+	}
+	n := len(e.Lines)
+	for k := 1; k <= n; k++ {
+		var i int
+		if forward {
+			i = (from - 1 + k) % n
+		} else {
+			i = ((from-1-k)%n + n) % n
+		}
+		if match(e.Lines[i]) {
+			return i + 1, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+// lineMatcher returns a matcher for needle as used by S and by search
+// addresses: a leading '~' makes needle a regular expression, see
+// regexPrefix, and an empty needle repeats the last search.
+func (e *Edlin) lineMatcher(needle string) (func(string) bool, error) {
+	needle, regex := regexPrefix(needle)
+	if needle == "" {
+		needle = e.lastNeedle
+		regex = regex || e.lastRegexp
+	}
+	e.lastNeedle = needle
+	e.lastRegexp = regex
+	return matcher(needle, regex)
+}
+
+// transfer inserts files before line p0. rest is the path of a file, a glob
+// matching files inserted one after the other or - for Stdin, optionally
+// followed by :start[,end], the range of lines to insert from each file.
+func (e *Edlin) transfer(params []int, rest string) error {
+	p0, err := params1(params)
+	if err != nil {
+		return err
+	}
+	if p0 == 0 {
+		p0 = e.Current
+	}
+	if p0 == 0 {
+		p0 = 1
+	}
+	path, start, end, ok := parseTransfer(strings.TrimSpace(rest))
+	if !ok || p0 > len(e.Lines)+1 {
+		return ErrEntry
+	}
+
+	var temp []string
+	if path == "-" {
+		if e.Stdin == nil {
+			return ErrNoStdin
+		}
+		rd := bufio.NewReader(e.Stdin)
+		enc, _ := readBOM(rd, e.Encoding)
+		temp, err = readLineRange(ioutil.NopCloser(rd), "-", newLineSplitter(enc, !e.Binary), start, end)
+		if err != nil {
+			return err
+		}
+	} else {
+		paths := []string{path}
+		if strings.ContainsAny(path, "*?[") {
+			paths, err = e.FS.Glob(path)
+			if err == nil && len(paths) == 0 {
+				err = os.ErrNotExist
+			}
+			if err != nil {
+				return &IOError{"read", path, err}
+			}
+		}
+		for _, path := range paths {
+			fh, enc, err := e.openText(path, e.Encoding)
+			if err != nil {
+				return &IOError{"read", path, err}
+			}
+			lines, err := readLineRange(fh, path, newLineSplitter(enc, !e.Binary), start, end)
+			if err != nil {
+				return err
+			}
+			temp = append(temp, lines...)
+		}
+	}
+	if len(temp) > 0 {
+		e.copyIntl(temp, 1, p0)
+	}
+	return nil
+}
+
+// parseTransfer splits the argument of T into the path and the range of
+// lines, end is zero if the range goes to the end of the file.
+func parseTransfer(arg string) (path string, start, end int, ok bool) {
+	path, start = arg, 1
+	if i := strings.LastIndexByte(arg, ':'); i > 0 {
+		r := arg[i+1:]
+		s0, s1 := r, r
+		if comma := strings.IndexByte(r, ','); comma >= 0 {
+			s0, s1 = r[:comma], r[comma+1:]
+		}
+		n0, err0 := strconv.Atoi(s0)
+		n1, err1 := strconv.Atoi(s1)
+		switch {
+		case s0 == "" && err1 == nil:
+			path, end = arg[:i], n1
+		case err0 == nil && s1 == "":
+			path, start = arg[:i], n0
+		case err0 == nil && err1 == nil:
+			path, start, end = arg[:i], n0, n1
+		}
+	}
+	ok = path != "" && start > 0 && end >= 0 && (end == 0 || start <= end)
+	return path, start, end, ok
+}
+
+func (e *Edlin) write(params []int) error {
+	var n int
+	switch len(params) {
+	case 0:
+		n = len(e.Lines) / 2
+	case 1:
+		n = params[0]
+		if n > len(e.Lines) {
+			n = len(e.Lines)
+		}
+	default:
+		return ErrEntry
+	}
+	backup := e.tempSize()
+	if n > 0 {
+		if err := e.do(change{written: n, backup: backup}); err != nil {
+			return err
+		}
+	} else if err := e.writeBackup(nil); err != nil {
+		return err
+	}
+	e.Current = 1
+	return nil
+}
+
+// writeBackup appends lines to the temporary file.
+func (e *Edlin) writeBackup(lines []string) error {
+	fh, err := e.openTemp()
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fh)
+	for i := range lines {
+		w.WriteString(lines[i])
+		w.WriteByte('\n')
+	}
+	err = w.Flush()
+	if err1 := fh.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return &IOError{"write", e.tempPath, err}
+	}
+	return nil
+}
+
+// SUPPORT ////////////////////////////////////////////////////////////////////////////////////////////
+
+func (e *Edlin) yesno(prompt string, strict bool) (byte, error) {
+	if !strict {
+		return e.choose(prompt, "")
+	}
+	return e.choose(prompt, "YN")
+}
+
+// choose asks the user to press one of the keys in keys (upper case letters)
+// and returns it in upper case. If keys is empty any key is accepted. In
+// batch mode the answer is the first character of the next line of the
+// script, an empty line or the end of the script answers 'N'.
+func (e *Edlin) choose(prompt string, keys string) (byte, error) {
+	valid := func(key byte) bool {
+		return keys == "" || strings.IndexByte(keys, key) >= 0
+	}
+
+	if e.Batch {
+		for {
+			line, err := e.readScriptLine()
+			if err == io.EOF {
+				return 'N', nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			if line == "" {
+				line = "N"
+			}
+			key := line[0] & ^uint8(0x20)
+			if valid(key) {
+				return key, nil
+			}
+		}
+	}
+
+	for {
+		fmt.Fprintf(e.Stdout, prompt)
+
+		rr := e.newRawReader()
+		buf, err := rr.Next()
+		if err != nil {
+			rr.Close()
+			return 0, err
+		}
+		key := buf[0]
+		if key == 0x3 { // Ctrl-C
+			fmt.Fprintf(e.Stdout, "^C")
+			rr.Close()
+			return 0, ErrInterrupted
+		}
+		fmt.Fprintf(e.Stdout, "%c", key)
+		rr.Close()
+
+		key = key & ^uint8(0x20)
+		if valid(key) {
+			return key, nil
+		}
+	}
+}
+
+// regexPrefix removes the prefix of a search string and reports whether
+// the rest is a regular expression: a leading '~' makes it one, a leading
+// "\~" stands for a literal '~' at the start of the text.
+func regexPrefix(needle string) (string, bool) {
+	switch {
+	case strings.HasPrefix(needle, "~"):
+		return needle[1:], true
+	case strings.HasPrefix(needle, `\~`):
+		return needle[1:], false
+	}
+	return needle, false
+}
+
+// matcher returns a function that reports whether a line contains needle,
+// if regex is set needle is a regular expression.
+func matcher(needle string, regex bool) (func(string) bool, error) {
+	if regex {
+		re, err := compileRegexp(needle)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	return func(s string) bool {
+		return strings.Contains(s, needle)
+	}, nil
+}
+
+func compileRegexp(needle string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(needle)
+	if err != nil {
+		return nil, &PatternError{needle, err}
+	}
+	return re, nil
+}
+
+func params1(params []int) (int, error) {
+	switch len(params) {
+	case 0:
+		return 0, nil
+	case 1:
+		return params[0], nil
+	default:
+		return 0, ErrEntry
+	}
+}
+
+func params2(params []int) (int, int, error) {
+	var p0, p1 int
+	switch len(params) {
+	case 0:
+		// use defaults
+	case 2:
+		p1 = params[1]
+		fallthrough
+	case 1:
+		p0 = params[0]
+	default:
+		return 0, 0, ErrEntry
+	}
+	if p1 != 0 && p0 > p1 {
+		return 0, 0, ErrEntry
+	}
+	return p0, p1, nil
+}
+
+// rawReader reads keys from the terminal while it is in raw mode.
+type rawReader struct {
+	e *Edlin
+}
+
+func (e *Edlin) newRawReader() *rawReader {
+	restore := e.Term.Raw()
+	e.termMu.Lock()
+	e.restoreTerm = restore
+	e.termMu.Unlock()
+	return &rawReader{e}
+}
+
+func (rr *rawReader) Next() ([]byte, error) {
+	return rr.e.readKey()
+}
+
+func (rr *rawReader) Close() {
+	rr.e.RestoreTerminal()
+	fmt.Fprintf(rr.e.Stdout, "\n")
+}
+
+// readKey reads a key from the terminal, mu is released while waiting for it.
+func (e *Edlin) readKey() ([]byte, error) {
+	e.mu.Unlock()
+	defer e.mu.Lock()
+	return e.Term.ReadKey()
+}
+
+// readTermLine is readKey for a line in batch mode.
+func (e *Edlin) readTermLine() ([]byte, error) {
+	e.mu.Unlock()
+	defer e.mu.Lock()
+	return e.Term.ReadLine()
+}
+
+// RestoreTerminal takes the terminal out of raw mode, if it is in raw mode.
+// It can be called from any goroutine, for example by a signal handler.
+func (e *Edlin) RestoreTerminal() {
+	e.termMu.Lock()
+	restore := e.restoreTerm
+	e.restoreTerm = nil
+	e.termMu.Unlock()
+	if restore != nil {
+		restore()
+	}
+}
+
+// Interrupt asks the command being executed to stop as soon as possible, it
+// can be called from any goroutine, for example when SIGINT is received.
+func (e *Edlin) Interrupt() {
+	atomic.StoreInt32(&e.interrupted, 1)
+}
+
+// checkInterrupt returns ErrInterrupted if Interrupt was called during the
+// current command.
+func (e *Edlin) checkInterrupt() error {
+	if atomic.LoadInt32(&e.interrupted) != 0 {
+		return ErrInterrupted
+	}
+	return nil
+}
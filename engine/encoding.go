@@ -0,0 +1,334 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding is the character encoding of a file, lines are decoded to UTF-8
+// when they are read and encoded back when the file is saved. A nil
+// *Encoding is UTF-8 without a byte order mark.
+type Encoding struct {
+	Name  string
+	bom   []byte // byte order mark, written at the start of the file
+	kind  encodingKind
+	table *[128]rune // characters 0x80-0xff of single byte encodings
+
+	unmap map[rune]byte // table reversed
+}
+
+type encodingKind uint8
+
+const (
+	utf8Kind encodingKind = iota
+	singleByteKind
+	utf16LEKind
+	utf16BEKind
+)
+
+var (
+	UTF8    = &Encoding{Name: "UTF-8"}
+	UTF8BOM = &Encoding{Name: "UTF-8-BOM", bom: []byte{0xef, 0xbb, 0xbf}}
+	// UTF-16 files are always written with a byte order mark.
+	UTF16LE = &Encoding{Name: "UTF-16LE", bom: []byte{0xff, 0xfe}, kind: utf16LEKind}
+	UTF16BE = &Encoding{Name: "UTF-16BE", bom: []byte{0xfe, 0xff}, kind: utf16BEKind}
+	Latin1  = &Encoding{Name: "ISO-8859-1", kind: singleByteKind}
+	CP437   = &Encoding{Name: "CP437", kind: singleByteKind, table: &cp437}
+	CP850   = &Encoding{Name: "CP850", kind: singleByteKind, table: &cp850}
+)
+
+// Encodings are all the encodings known to LookupEncoding.
+var Encodings = []*Encoding{UTF8, UTF8BOM, UTF16LE, UTF16BE, Latin1, CP437, CP850}
+
+// init builds the reversed tables here rather than when they are first
+// needed, so that encode can be called by more than one goroutine.
+func init() {
+	for _, enc := range Encodings {
+		if enc.table == nil {
+			continue
+		}
+		enc.unmap = make(map[rune]byte, len(enc.table))
+		for i, r := range enc.table {
+			enc.unmap[r] = byte(i + 0x80)
+		}
+	}
+}
+
+var encodingAliases = map[string]*Encoding{
+	"UTF8":    UTF8,
+	"UTF-16":  UTF16LE,
+	"LATIN1":  Latin1,
+	"LATIN-1": Latin1,
+	"IBM437":  CP437,
+	"IBM850":  CP850,
+}
+
+// LookupEncoding returns the encoding called name, ignoring case.
+func LookupEncoding(name string) (*Encoding, bool) {
+	name = strings.ToUpper(name)
+	for _, enc := range Encodings {
+		if enc.Name == name {
+			return enc, true
+		}
+	}
+	enc, ok := encodingAliases[name]
+	return enc, ok
+}
+
+func (enc *Encoding) String() string {
+	if enc == nil {
+		return UTF8.Name
+	}
+	return enc.Name
+}
+
+func (enc *Encoding) getKind() encodingKind {
+	if enc == nil {
+		return utf8Kind
+	}
+	return enc.kind
+}
+
+// split splits the lines of a file in this encoding, see lineSplitter.
+func (enc *Encoding) split(counts *eolCounts, data []byte, atEOF bool) (int, []byte, error) {
+	switch kind := enc.getKind(); kind {
+	case utf16LEKind, utf16BEKind:
+		return splitLines16(counts, data, atEOF, kind == utf16BEKind)
+	default:
+		return splitLines(counts, data, atEOF)
+	}
+}
+
+// ctrlZ returns the ^Z end of file marker in this encoding.
+func (enc *Encoding) ctrlZ() []byte {
+	b, _ := enc.encode(nil, "\x1a")
+	return b
+}
+
+// indexCtrlZ returns the index of the first ^Z in data, or -1.
+func (enc *Encoding) indexCtrlZ(data []byte) int {
+	switch kind := enc.getKind(); kind {
+	case utf16LEKind, utf16BEKind:
+		for i := 0; i+1 < len(data); i += 2 {
+			if unit16(data[i:], kind == utf16BEKind) == 0x1a {
+				return i
+			}
+		}
+		return -1
+	default:
+		return bytes.IndexByte(data, 0x1a)
+	}
+}
+
+// decode converts a line from this encoding to UTF-8. Invalid UTF-8 is
+// left as it is, invalid UTF-16 is replaced by U+FFFD.
+func (enc *Encoding) decode(b []byte) string {
+	switch kind := enc.getKind(); kind {
+	case singleByteKind:
+		var sb strings.Builder
+		for _, c := range b {
+			switch {
+			case c < 0x80:
+				sb.WriteByte(c)
+			case enc.table == nil:
+				sb.WriteRune(rune(c))
+			default:
+				sb.WriteRune(enc.table[c-0x80])
+			}
+		}
+		return sb.String()
+	case utf16LEKind, utf16BEKind:
+		units := make([]uint16, 0, len(b)/2)
+		for i := 0; i+1 < len(b); i += 2 {
+			units = append(units, unit16(b[i:], kind == utf16BEKind))
+		}
+		s := string(utf16.Decode(units))
+		if len(b)%2 != 0 {
+			s += string(utf8.RuneError)
+		}
+		return s
+	default:
+		return string(b)
+	}
+}
+
+// encode appends s, converted to this encoding, to dst. Returns false if s
+// contains characters that can not be encoded, which are skipped.
+func (enc *Encoding) encode(dst []byte, s string) ([]byte, bool) {
+	kind := enc.getKind()
+	if kind == utf8Kind {
+		return append(dst, s...), true
+	}
+	ok := true
+	for i, r := range s {
+		if r == utf8.RuneError && !strings.HasPrefix(s[i:], string(utf8.RuneError)) {
+			// invalid UTF-8
+			ok = false
+			continue
+		}
+		switch kind {
+		case singleByteKind:
+			switch c, found := enc.unmap[r]; {
+			case r < 0x80:
+				dst = append(dst, byte(r))
+			case enc.table == nil && r < 0x100:
+				dst = append(dst, byte(r))
+			case found:
+				dst = append(dst, c)
+			default:
+				ok = false
+			}
+		case utf16LEKind, utf16BEKind:
+			var units []uint16
+			if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+				units = []uint16{uint16(r1), uint16(r2)}
+			} else {
+				units = []uint16{uint16(r)}
+			}
+			for _, u := range units {
+				if kind == utf16BEKind {
+					dst = append(dst, byte(u>>8), byte(u))
+				} else {
+					dst = append(dst, byte(u), byte(u>>8))
+				}
+			}
+		}
+	}
+	return dst, ok
+}
+
+func unit16(b []byte, bigEndian bool) uint16 {
+	if bigEndian {
+		return uint16(b[0])<<8 | uint16(b[1])
+	}
+	return uint16(b[1])<<8 | uint16(b[0])
+}
+
+// splitLines16 is splitLines for UTF-16.
+func splitLines16(counts *eolCounts, data []byte, atEOF bool, bigEndian bool) (int, []byte, error) {
+	for i := 0; i+1 < len(data); i += 2 {
+		switch unit16(data[i:], bigEndian) {
+		case '\n':
+			counts.add(LF)
+			return i + 2, data[:i], nil
+		case '\r':
+			switch {
+			case i+3 < len(data) && unit16(data[i+2:], bigEndian) == '\n':
+				counts.add(CRLF)
+				return i + 4, data[:i], nil
+			case i+3 < len(data) || atEOF:
+				counts.add(CR)
+				return i + 2, data[:i], nil
+			default:
+				// don't know yet if it is CR or CRLF
+				return 0, nil, nil
+			}
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// readBOM reads the byte order mark at the start of rd. If enc is nil the
+// encoding is detected from the byte order mark, defaulting to UTF-8,
+// otherwise the byte order mark is only read if it belongs to enc.
+func readBOM(rd *bufio.Reader, enc *Encoding) (*Encoding, int) {
+	for _, bomEnc := range []*Encoding{UTF8BOM, UTF16LE, UTF16BE} {
+		b, _ := rd.Peek(len(bomEnc.bom))
+		if !bytes.Equal(b, bomEnc.bom) {
+			continue
+		}
+		if enc == nil || enc == bomEnc || (enc == UTF8 && bomEnc == UTF8BOM) {
+			rd.Discard(len(b))
+			return bomEnc, len(b)
+		}
+	}
+	if enc == nil {
+		enc = UTF8
+	}
+	return enc, 0
+}
+
+// openText opens path and reads its byte order mark, see readBOM.
+func (e *Edlin) openText(path string, enc *Encoding) (io.ReadCloser, *Encoding, error) {
+	fh, err := e.FS.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	rd := bufio.NewReader(fh)
+	enc, _ = readBOM(rd, enc)
+	return &multiReadCloser{rd, []io.Closer{fh}}, enc, nil
+}
+
+// decodedReader reads the lines of a file in enc as UTF-8 lines ending with
+// LF.
+type decodedReader struct {
+	sc  *bufio.Scanner
+	enc *Encoding
+	buf []byte
+}
+
+func newDecodedReader(rd io.Reader, ls *lineSplitter) *decodedReader {
+	sc := newLineScanner(rd)
+	sc.Split(ls.split)
+	return &decodedReader{sc: sc, enc: ls.enc}
+}
+
+func (r *decodedReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if !r.sc.Scan() {
+			if err := r.sc.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		r.buf = append(append(r.buf[:0], r.enc.decode(r.sc.Bytes())...), '\n')
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+var cp437 = [128]rune{
+	0x00c7, 0x00fc, 0x00e9, 0x00e2, 0x00e4, 0x00e0, 0x00e5, 0x00e7,
+	0x00ea, 0x00eb, 0x00e8, 0x00ef, 0x00ee, 0x00ec, 0x00c4, 0x00c5,
+	0x00c9, 0x00e6, 0x00c6, 0x00f4, 0x00f6, 0x00f2, 0x00fb, 0x00f9,
+	0x00ff, 0x00d6, 0x00dc, 0x00a2, 0x00a3, 0x00a5, 0x20a7, 0x0192,
+	0x00e1, 0x00ed, 0x00f3, 0x00fa, 0x00f1, 0x00d1, 0x00aa, 0x00ba,
+	0x00bf, 0x2310, 0x00ac, 0x00bd, 0x00bc, 0x00a1, 0x00ab, 0x00bb,
+	0x2591, 0x2592, 0x2593, 0x2502, 0x2524, 0x2561, 0x2562, 0x2556,
+	0x2555, 0x2563, 0x2551, 0x2557, 0x255d, 0x255c, 0x255b, 0x2510,
+	0x2514, 0x2534, 0x252c, 0x251c, 0x2500, 0x253c, 0x255e, 0x255f,
+	0x255a, 0x2554, 0x2569, 0x2566, 0x2560, 0x2550, 0x256c, 0x2567,
+	0x2568, 0x2564, 0x2565, 0x2559, 0x2558, 0x2552, 0x2553, 0x256b,
+	0x256a, 0x2518, 0x250c, 0x2588, 0x2584, 0x258c, 0x2590, 0x2580,
+	0x03b1, 0x00df, 0x0393, 0x03c0, 0x03a3, 0x03c3, 0x00b5, 0x03c4,
+	0x03a6, 0x0398, 0x03a9, 0x03b4, 0x221e, 0x03c6, 0x03b5, 0x2229,
+	0x2261, 0x00b1, 0x2265, 0x2264, 0x2320, 0x2321, 0x00f7, 0x2248,
+	0x00b0, 0x2219, 0x00b7, 0x221a, 0x207f, 0x00b2, 0x25a0, 0x00a0,
+}
+
+var cp850 = [128]rune{
+	0x00c7, 0x00fc, 0x00e9, 0x00e2, 0x00e4, 0x00e0, 0x00e5, 0x00e7,
+	0x00ea, 0x00eb, 0x00e8, 0x00ef, 0x00ee, 0x00ec, 0x00c4, 0x00c5,
+	0x00c9, 0x00e6, 0x00c6, 0x00f4, 0x00f6, 0x00f2, 0x00fb, 0x00f9,
+	0x00ff, 0x00d6, 0x00dc, 0x00f8, 0x00a3, 0x00d8, 0x00d7, 0x0192,
+	0x00e1, 0x00ed, 0x00f3, 0x00fa, 0x00f1, 0x00d1, 0x00aa, 0x00ba,
+	0x00bf, 0x00ae, 0x00ac, 0x00bd, 0x00bc, 0x00a1, 0x00ab, 0x00bb,
+	0x2591, 0x2592, 0x2593, 0x2502, 0x2524, 0x00c1, 0x00c2, 0x00c0,
+	0x00a9, 0x2563, 0x2551, 0x2557, 0x255d, 0x00a2, 0x00a5, 0x2510,
+	0x2514, 0x2534, 0x252c, 0x251c, 0x2500, 0x253c, 0x00e3, 0x00c3,
+	0x255a, 0x2554, 0x2569, 0x2566, 0x2560, 0x2550, 0x256c, 0x00a4,
+	0x00f0, 0x00d0, 0x00ca, 0x00cb, 0x00c8, 0x0131, 0x00cd, 0x00ce,
+	0x00cf, 0x2518, 0x250c, 0x2588, 0x2584, 0x00a6, 0x00cc, 0x2580,
+	0x00d3, 0x00df, 0x00d4, 0x00d2, 0x00f5, 0x00d5, 0x00b5, 0x00fe,
+	0x00de, 0x00da, 0x00db, 0x00d9, 0x00fd, 0x00dd, 0x00af, 0x00b4,
+	0x00ad, 0x00b1, 0x2017, 0x00be, 0x00b6, 0x00a7, 0x00f7, 0x00b8,
+	0x00b0, 0x00a8, 0x00b7, 0x00b9, 0x00b3, 0x00b2, 0x25a0, 0x00a0,
+}
@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// EOL is the sequence of characters that ends a line.
+type EOL uint8
+
+const (
+	LF   EOL = iota // Unix
+	CRLF            // DOS and Windows
+	CR              // classic Mac OS
+)
+
+var eolNames = [...]string{"LF", "CRLF", "CR"}
+
+func (eol EOL) String() string {
+	return eolNames[eol]
+}
+
+// seq returns the characters ending a line.
+func (eol EOL) seq() string {
+	return [...]string{"\n", "\r\n", "\r"}[eol]
+}
+
+// parseEOL returns the EOL called name, ignoring case.
+func parseEOL(name string) (EOL, bool) {
+	for i := range eolNames {
+		if strings.EqualFold(name, eolNames[i]) {
+			return EOL(i), true
+		}
+	}
+	return 0, false
+}
+
+// eolCounts is the number of lines ending with each EOL.
+type eolCounts [len(eolNames)]int
+
+// lineSplitter splits the lines of a file encoded in enc, ending with LF,
+// CRLF or CR, and records how the file ends.
+type lineSplitter struct {
+	enc     *Encoding
+	dosEOF  bool       // the first ^Z ends the file
+	counts  *eolCounts // line endings of the lines split, if not nil
+	noEOL   bool       // the last line split has no line ending
+	ctrlZ   bool       // the file ended with ^Z
+	ignored bool       // there was something after the ^Z
+}
+
+func newLineSplitter(enc *Encoding, dosEOF bool) *lineSplitter {
+	return &lineSplitter{enc: enc, dosEOF: dosEOF}
+}
+
+// split is a bufio.SplitFunc.
+func (ls *lineSplitter) split(data []byte, atEOF bool) (int, []byte, error) {
+	if ls.ctrlZ {
+		ls.ignored = ls.ignored || len(data) > 0
+		return len(data), nil, nil
+	}
+	if ls.dosEOF {
+		if z := ls.enc.indexCtrlZ(data); z == 0 {
+			ls.ctrlZ = true
+			ls.ignored = len(data) > len(ls.enc.ctrlZ())
+			return len(data), nil, nil
+		} else if z > 0 {
+			// the line before ^Z is the last one
+			data, atEOF = data[:z], true
+		}
+	}
+	advance, token, err := ls.enc.split(ls.counts, data, atEOF)
+	if token != nil {
+		ls.noEOL = advance == len(token)
+	}
+	return advance, token, err
+}
+
+func splitLines(counts *eolCounts, data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	i := bytes.IndexAny(data, "\r\n")
+	switch {
+	case i < 0:
+		if atEOF {
+			// last line without a line ending
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	case data[i] == '\n':
+		counts.add(LF)
+		return i + 1, data[:i], nil
+	case i+1 < len(data) && data[i+1] == '\n':
+		counts.add(CRLF)
+		return i + 2, data[:i], nil
+	case i+1 < len(data) || atEOF:
+		counts.add(CR)
+		return i + 1, data[:i], nil
+	default:
+		// don't know yet if it is CR or CRLF
+		return 0, nil, nil
+	}
+}
+
+func (counts *eolCounts) add(eol EOL) {
+	if counts != nil {
+		counts[eol]++
+	}
+}
+
+// mixed returns true if lines end in more than one way.
+func (counts *eolCounts) mixed() bool {
+	n := 0
+	for _, c := range counts {
+		if c > 0 {
+			n++
+		}
+	}
+	return n > 1
+}
+
+// most returns the most common line ending, ties go to the first one in the
+// order LF, CRLF, CR. Returns false if no line was counted.
+func (counts *eolCounts) most() (EOL, bool) {
+	eol, max := LF, 0
+	for i, c := range counts {
+		if c > max {
+			eol, max = EOL(i), c
+		}
+	}
+	return eol, max > 0
+}
+
+func (counts *eolCounts) String() string {
+	var parts []string
+	for i, c := range counts {
+		if c > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", c, EOL(i)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// reportEOLs tells the user, once, that the lines of the input file end in
+// different ways and all of them will be saved with EOL.
+func (e *Edlin) reportEOLs() {
+	if e.eolReported || !e.inputEOLs.mixed() {
+		return
+	}
+	e.eolReported = true
+	fmt.Fprintf(e.Stdout, "Mixed line endings (%v), saving with %v\n", &e.inputEOLs, e.EOL)
+}
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrEntry is returned for malformed commands and invalid line numbers.
+	ErrEntry = errors.New("Entry error")
+	// ErrNotFound is returned when a search doesn't match any line.
+	ErrNotFound      = errors.New("Not found")
+	ErrNothingToUndo = errors.New("Nothing to undo")
+	ErrNothingToRedo = errors.New("Nothing to redo")
+	// ErrInterrupted is returned when a command is cancelled with Ctrl-C.
+	ErrInterrupted = errors.New("Interrupted")
+	// ErrChanged is returned when the user doesn't want to overwrite a file
+	// that was changed by someone else.
+	ErrChanged = errors.New("File changed on disk, not saved")
+	// ErrReadOnly is returned by the commands that save the file if
+	// ReadOnly is set.
+	ErrReadOnly = errors.New("File is read-only")
+	// ErrLocked is returned by FS.Lock if the lock is held by someone else.
+	ErrLocked = errors.New("File locked")
+	// ErrSameFile is returned by X when asked to write to the file being
+	// edited.
+	ErrSameFile = errors.New("Can't write lines to the file being edited, use F to save it")
+	// ErrNoStdin is returned by T- if Stdin is nil.
+	ErrNoStdin = errors.New("Standard input not available")
+)
+
+// IOError is returned when reading or writing a file fails.
+type IOError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (err *IOError) Error() string {
+	return fmt.Sprintf("%s %s: %v", err.Op, err.Path, err.Err)
+}
+
+func (err *IOError) Unwrap() error {
+	return err.Err
+}
+
+// RangeError is returned when a line address evaluates to a line outside
+// of the buffer.
+type RangeError struct {
+	Addr string
+	Line int
+}
+
+func (err *RangeError) Error() string {
+	return fmt.Sprintf("Line %d out of range: %s", err.Line, err.Addr)
+}
+
+// MarkError is returned when an address refers to a mark that was never
+// set or whose line was deleted.
+type MarkError struct {
+	Name byte
+}
+
+func (err *MarkError) Error() string {
+	return fmt.Sprintf("Mark '%c not set", err.Name)
+}
+
+// PatternError is returned when a regular expression can not be compiled.
+type PatternError struct {
+	Pattern string
+	Err     error
+}
+
+func (err *PatternError) Error() string {
+	return fmt.Sprintf("Invalid pattern %q: %v", err.Pattern, err.Err)
+}
+
+func (err *PatternError) Unwrap() error {
+	return err.Err
+}
+
+// LockError is returned by Lock when another session is editing the file.
+type LockError struct {
+	Path string
+	PID  int
+	User string
+}
+
+func (err *LockError) Error() string {
+	if err.PID == 0 {
+		return fmt.Sprintf("%s is being edited by someone else", err.Path)
+	}
+	return fmt.Sprintf("%s is being edited by %s (pid %d)", err.Path, err.User, err.PID)
+}
+
+func (err *LockError) Unwrap() error {
+	return ErrLocked
+}
+
+// EncodingError is returned when saving a file with characters that can't be
+// represented in its encoding.
+type EncodingError struct {
+	Encoding string
+	Lines    []int // the first lines containing them
+	Count    int   // the number of lines containing them
+}
+
+// maxEncodingErrorLines is the number of lines listed by an EncodingError.
+const maxEncodingErrorLines = 10
+
+func (err *EncodingError) add(line int) {
+	err.Count++
+	if len(err.Lines) < maxEncodingErrorLines {
+		err.Lines = append(err.Lines, line)
+	}
+}
+
+func (err *EncodingError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Characters not representable in %s on line", err.Encoding)
+	if err.Count > 1 {
+		sb.WriteByte('s')
+	}
+	for i, line := range err.Lines {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, " %d", line)
+	}
+	if err.Count > len(err.Lines) {
+		fmt.Fprintf(&sb, " and %d more", err.Count-len(err.Lines))
+	}
+	return sb.String()
+}
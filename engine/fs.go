@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// FS is the file system used by Edlin to read and write files.
+type FS interface {
+	Open(name string) (io.ReadCloser, error)
+	OpenFile(name string, flag int, perm os.FileMode) (io.WriteCloser, error)
+	Stat(name string) (os.FileInfo, error)
+	Truncate(name string, size int64) error
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+
+	// EvalSymlinks returns name with all symbolic links resolved.
+	EvalSymlinks(name string) (string, error)
+
+	// Glob returns the names of the files matching pattern, see
+	// filepath.Glob.
+	Glob(pattern string) ([]string, error)
+
+	// Sync flushes the contents of the file or directory name to disk.
+	Sync(name string) error
+
+	// CopyMetadata gives dst the permissions, owner and extended
+	// attributes of src.
+	CopyMetadata(src, dst string) error
+
+	// Lock takes an exclusive advisory lock on name, creating it if it
+	// doesn't exist. The lock is released by closing the returned value.
+	// Returns ErrLocked if someone else holds it.
+	Lock(name string) (io.Closer, error)
+}
+
+// OSFS implements FS on top of the operating system's file system.
+type OSFS struct{}
+
+func (OSFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (OSFS) OpenFile(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (OSFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFS) Truncate(name string, size int64) error {
+	return os.Truncate(name, size)
+}
+
+func (OSFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) EvalSymlinks(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
+
+func (OSFS) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+func (OSFS) Sync(name string) error {
+	fh, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	err = fh.Sync()
+	if err1 := fh.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func (OSFS) CopyMetadata(src, dst string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		if err := os.Chown(dst, int(st.Uid), int(st.Gid)); err != nil {
+			return err
+		}
+	}
+	// chmod after chown, which clears the setuid and setgid bits
+	if err := os.Chmod(dst, fi.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	return copyXattrs(src, dst)
+}
+
+func (OSFS) Lock(name string) (io.Closer, error) {
+	for {
+		fh, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		err = unix.Flock(int(fh.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == unix.EWOULDBLOCK {
+			fh.Close()
+			return nil, ErrLocked
+		}
+		if err != nil {
+			fh.Close()
+			return nil, &os.PathError{Op: "flock", Path: name, Err: err}
+		}
+		// the holder of the lock removes the file before releasing it, if
+		// that happened while we were waiting we locked a file nobody else
+		// will see.
+		fi1, err1 := fh.Stat()
+		fi2, err2 := os.Stat(name)
+		if err1 == nil && err2 == nil && os.SameFile(fi1, fi2) {
+			return fh, nil
+		}
+		fh.Close()
+	}
+}
+
+func copyXattrs(src, dst string) error {
+	sz, err := unix.Listxattr(src, nil)
+	if err == unix.ENOTSUP || sz == 0 {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "listxattr", Path: src, Err: err}
+	}
+	buf := make([]byte, sz)
+	sz, err = unix.Listxattr(src, buf)
+	if err != nil {
+		return &os.PathError{Op: "listxattr", Path: src, Err: err}
+	}
+	for _, name := range splitNul(buf[:sz]) {
+		sz, err := unix.Getxattr(src, name, nil)
+		if err != nil {
+			return &os.PathError{Op: "getxattr", Path: src, Err: err}
+		}
+		val := make([]byte, sz)
+		sz, err = unix.Getxattr(src, name, val)
+		if err != nil {
+			return &os.PathError{Op: "getxattr", Path: src, Err: err}
+		}
+		if err := unix.Setxattr(dst, name, val[:sz], 0); err != nil {
+			return &os.PathError{Op: "setxattr", Path: dst, Err: err}
+		}
+	}
+	return nil
+}
+
+// splitNul splits a list of NUL terminated strings.
+func splitNul(buf []byte) []string {
+	var r []string
+	for len(buf) > 0 {
+		i := 0
+		for i < len(buf) && buf[i] != 0 {
+			i++
+		}
+		if i > 0 {
+			r = append(r, string(buf[:i]))
+		}
+		if i < len(buf) {
+			i++
+		}
+		buf = buf[i:]
+	}
+	return r
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// maxHistory is the number of commands remembered by Input.
+const maxHistory = 1000
+
+// loadHistory reads the commands saved in HistoryFile by previous sessions.
+func (e *Edlin) loadHistory() {
+	if e.historyLoaded {
+		return
+	}
+	e.historyLoaded = true
+	if e.HistoryFile == "" {
+		return
+	}
+	fh, err := e.FS.Open(e.HistoryFile)
+	if err != nil {
+		return
+	}
+	defer fh.Close()
+	var lines []string
+	rd := newLineScanner(fh)
+	for rd.Scan() {
+		if rd.Text() != "" {
+			lines = append(lines, rd.Text())
+		}
+	}
+	if len(lines) > 2*maxHistory {
+		// only rewrite the file once in a while
+		lines = lines[len(lines)-maxHistory:]
+		e.writeHistory(lines, os.O_TRUNC)
+	}
+	e.history = append(lines, e.history...)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// addHistory records a command typed by the user.
+func (e *Edlin) addHistory(cmd string) {
+	if strings.TrimSpace(cmd) == "" || strings.ContainsAny(cmd, "\r\n") {
+		return
+	}
+	if len(e.history) > 0 && e.history[len(e.history)-1] == cmd {
+		return
+	}
+	if len(e.history) >= maxHistory {
+		copy(e.history, e.history[1:])
+		e.history = e.history[:len(e.history)-1]
+	}
+	e.history = append(e.history, cmd)
+	if e.HistoryFile != "" {
+		e.writeHistory([]string{cmd}, os.O_APPEND)
+	}
+}
+
+// writeHistory writes lines to HistoryFile, history is a convenience and
+// errors are ignored.
+func (e *Edlin) writeHistory(lines []string, flag int) {
+	fh, err := e.FS.OpenFile(e.HistoryFile, os.O_WRONLY|os.O_CREATE|flag, 0600)
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(fh)
+	for _, line := range lines {
+		w.WriteString(line)
+		w.WriteByte('\n')
+	}
+	w.Flush()
+	fh.Close()
+}
@@ -0,0 +1,280 @@
+package engine
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// maxUndo is the maximum number of command lines that can be undone.
+const maxUndo = 1000
+
+// change is a single modification of the buffer: the lines in old, starting
+// at line, were replaced by the lines in new.
+type change struct {
+	line     int
+	old, new []string
+
+	// written is the number of lines moved from the start of the buffer to
+	// the temporary file by W, backup is the size it had before they were
+	// written.
+	// The lines themselves are not kept in memory, undo reads them back.
+	written int
+	backup  int64
+
+	// read is the number of lines taken from the input file and appended
+	// to the buffer, undo gives them back to the input file.
+	read int
+
+	// marks are set on the new lines after the change is applied, lost are
+	// the marks of the lines removed by it, restored by undo.
+	marks, lost map[byte]int
+
+	// format is set for changes of the line ending or encoding used to
+	// save the file, made with O.
+	format         bool
+	oldEOL, newEOL EOL
+	oldEnc, newEnc *Encoding
+}
+
+// journalEntry records all the changes made by a single command line.
+type journalEntry struct {
+	changes       []change
+	before, after int  // value of Current before and after the command line
+	dirty         bool // value of Dirty before the command line
+}
+
+func (e *Edlin) begin() {
+	e.pending = &journalEntry{before: e.Current, dirty: e.Dirty}
+}
+
+func (e *Edlin) commit() {
+	je := e.pending
+	e.pending = nil
+	if je == nil || len(je.changes) == 0 {
+		return
+	}
+	je.after = e.Current
+	if len(e.undos) >= maxUndo {
+		copy(e.undos, e.undos[1:])
+		e.undos = e.undos[:len(e.undos)-1]
+	}
+	e.undos = append(e.undos, *je)
+	e.redos = nil
+}
+
+// do applies c to the buffer and records it in the journal, if applying c
+// fails the buffer is left unchanged.
+func (e *Edlin) do(c change) error {
+	if err := e.apply(&c, false); err != nil {
+		return err
+	}
+	if e.pending != nil {
+		e.pending.changes = append(e.pending.changes, c)
+	}
+	if c.read == 0 {
+		e.Dirty = true
+	}
+	return nil
+}
+
+// replaceLines replaces n lines starting at line with add.
+func (e *Edlin) replaceLines(line, n int, add []string) {
+	old := make([]string, n)
+	copy(old, e.Lines[line-1:line-1+n])
+	// changes that don't involve files can not fail
+	_ = e.do(change{line: line, old: old, new: add})
+}
+
+func (e *Edlin) apply(c *change, reverse bool) error {
+	e.changeCount++
+	if reverse {
+		defer func() {
+			for name, line := range c.lost {
+				e.marks[name] = line
+			}
+		}()
+	} else {
+		defer func() {
+			for name, line := range c.marks {
+				e.marks[name] = line
+			}
+		}()
+	}
+	if c.written > 0 {
+		if reverse {
+			fh, err := e.FS.Open(e.tempPath)
+			if err != nil {
+				return &IOError{"undo", e.tempPath, err}
+			}
+			if err := skip(fh, c.backup); err != nil {
+				fh.Close()
+				return &IOError{"undo", e.tempPath, err}
+			}
+			lines, err := readFileLines(fh, e.tempPath, newLineSplitter(nil, false))
+			if err != nil {
+				return err
+			}
+			if err := e.FS.Truncate(e.tempPath, c.backup); err != nil {
+				return &IOError{"undo", e.tempPath, err}
+			}
+			e.spliceLines(1, 0, lines)
+		} else {
+			if err := e.writeBackup(e.Lines[:c.written]); err != nil {
+				// don't leave half of the lines in the temporary file
+				if e.tempPath != "" {
+					e.FS.Truncate(e.tempPath, c.backup)
+				}
+				return err
+			}
+			c.lost = e.spliceLines(1, c.written, nil)
+		}
+		return nil
+	}
+	if c.format {
+		if reverse {
+			e.EOL, e.Encoding = c.oldEOL, c.oldEnc
+		} else {
+			e.EOL, e.Encoding = c.newEOL, c.newEnc
+		}
+		return nil
+	}
+	if c.read > 0 {
+		if reverse {
+			i := len(e.Lines) - c.read
+			e.pushback = append(append([]string{}, e.Lines[i:]...), e.pushback...)
+			e.spliceLines(i+1, c.read, nil)
+		} else {
+			e.spliceLines(len(e.Lines)+1, 0, e.pushback[:c.read])
+			e.pushback = e.pushback[c.read:]
+		}
+		return nil
+	}
+	if reverse {
+		e.spliceLines(c.line, len(c.new), c.old)
+	} else {
+		c.lost = e.spliceLines(c.line, len(c.old), c.new)
+	}
+	return nil
+}
+
+// spliceLines replaces ndel lines starting at line with add, marks after the
+// deleted lines are moved, marks on the deleted lines that were not replaced
+// are removed and returned. Lines waiting for G are moved the same way.
+func (e *Edlin) spliceLines(line, ndel int, add []string) (lost map[byte]int) {
+	adjust := func(m int) (int, bool) {
+		switch {
+		case m < line:
+			return m, true
+		case m < line+ndel:
+			return m, m-line < len(add)
+		default:
+			return m + len(add) - ndel, true
+		}
+	}
+	for name, m := range e.marks {
+		if m, ok := adjust(m); ok {
+			e.marks[name] = m
+		} else {
+			if lost == nil {
+				lost = make(map[byte]int)
+			}
+			lost[name] = m
+			delete(e.marks, name)
+		}
+	}
+	for i, m := range e.global {
+		if m, ok := adjust(m); ok {
+			e.global[i] = m
+		} else {
+			e.global[i] = 0
+		}
+	}
+
+	i := line - 1
+	oldlen := len(e.Lines)
+	switch delta := len(add) - ndel; {
+	case delta > 0:
+		e.Lines = append(e.Lines, make([]string, delta)...)
+		copy(e.Lines[i+len(add):], e.Lines[i+ndel:oldlen])
+	case delta < 0:
+		copy(e.Lines[i+len(add):], e.Lines[i+ndel:])
+		for j := oldlen + delta; j < oldlen; j++ {
+			e.Lines[j] = ""
+		}
+		e.Lines = e.Lines[:oldlen+delta]
+	}
+	copy(e.Lines[i:], add)
+	return lost
+}
+
+func (e *Edlin) undo(params []int) error {
+	n, err := params1(params)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		n = 1
+	}
+	e.commit()
+	defer e.begin()
+	for ; n > 0; n-- {
+		if len(e.undos) == 0 {
+			return ErrNothingToUndo
+		}
+		je := e.undos[len(e.undos)-1]
+		for i := len(je.changes) - 1; i >= 0; i-- {
+			if err := e.apply(&je.changes[i], true); err != nil {
+				// keep what is left of the entry so that it can be retried
+				e.undos[len(e.undos)-1].changes = je.changes[:i+1]
+				return err
+			}
+		}
+		e.undos = e.undos[:len(e.undos)-1]
+		e.Current = je.before
+		e.Dirty = je.dirty
+		e.redos = append(e.redos, je)
+	}
+	return nil
+}
+
+func (e *Edlin) redo(params []int) error {
+	n, err := params1(params)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		n = 1
+	}
+	e.commit()
+	defer e.begin()
+	for ; n > 0; n-- {
+		if len(e.redos) == 0 {
+			return ErrNothingToRedo
+		}
+		je := e.redos[len(e.redos)-1]
+		for i := range je.changes {
+			c := &je.changes[i]
+			if err := e.apply(c, false); err != nil {
+				e.redos[len(e.redos)-1].changes = je.changes[i:]
+				return err
+			}
+			if c.read == 0 {
+				e.Dirty = true
+			}
+		}
+		e.redos = e.redos[:len(e.redos)-1]
+		e.Current = je.after
+		e.undos = append(e.undos, je)
+	}
+	return nil
+}
+
+// skip discards the first n bytes of r.
+func skip(r io.Reader, n int64) error {
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, r, n)
+	return err
+}
@@ -0,0 +1,233 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// lineEditor is the line being typed by the user in Input, I and the line
+// editor, it is edited one rune at a time at the cursor and echoed on out.
+// Only backspaces and spaces are used to redraw the line, the terminal
+// cursor is always kept at the position of pos.
+type lineEditor struct {
+	out       io.Writer
+	buf       []rune
+	pos       int  // cursor position in buf
+	overwrite bool // typed characters replace the character under the cursor
+}
+
+func (le *lineEditor) String() string {
+	return string(le.buf)
+}
+
+// key handles the keys that move the cursor and edit the line, returns false
+// if key is not one of them.
+func (le *lineEditor) key(key []byte) bool {
+	switch {
+	case bytes.Equal(key, escSeqLeft):
+		le.left()
+	case bytes.Equal(key, escSeqRight):
+		le.right()
+	case isKey(key, escSeqHome, escSeqHomeXterm, escSeqHomeVT):
+		le.home()
+	case isKey(key, escSeqEnd, escSeqEndXterm, escSeqEndVT):
+		le.end()
+	case bytes.Equal(key, escSeqDelete):
+		le.del()
+	case bytes.Equal(key, escSeqInsert):
+		le.overwrite = !le.overwrite
+	default:
+		return false
+	}
+	return true
+}
+
+// insert types r at the cursor.
+func (le *lineEditor) insert(r rune) {
+	switch {
+	case le.pos == len(le.buf):
+		le.buf = append(le.buf, r)
+		le.pos++
+		fmt.Fprintf(le.out, "%s", displayRune(r))
+	case le.overwrite:
+		le.replace(le.pos, le.next(le.pos), []rune{r}, le.pos+1)
+	default:
+		le.replace(le.pos, le.pos, []rune{r}, le.pos+1)
+	}
+}
+
+// backspace deletes the rune before the cursor.
+func (le *lineEditor) backspace() {
+	if le.pos == 0 {
+		return
+	}
+	if r := le.buf[le.pos-1]; le.pos == len(le.buf) && cellWidth(r) > 0 {
+		le.buf = le.buf[:len(le.buf)-1]
+		le.pos--
+		fmt.Fprintf(le.out, "%s", strings.Repeat("\x08 \x08", cellWidth(r)))
+		return
+	}
+	le.replace(le.pos-1, le.pos, nil, le.pos-1)
+}
+
+// del deletes the character under the cursor, returns false if the cursor
+// is at the end of the line.
+func (le *lineEditor) del() bool {
+	if le.pos >= len(le.buf) {
+		return false
+	}
+	le.replace(le.pos, le.next(le.pos), nil, le.pos)
+	return true
+}
+
+// left moves the cursor to the previous character.
+func (le *lineEditor) left() {
+	if le.pos > 0 {
+		le.moveTo(le.start(le.pos - 1))
+	}
+}
+
+// right moves the cursor to the next character, returns false if the
+// cursor is at the end of the line.
+func (le *lineEditor) right() bool {
+	if le.pos >= len(le.buf) {
+		return false
+	}
+	le.moveTo(le.next(le.pos))
+	return true
+}
+
+func (le *lineEditor) home() {
+	le.moveTo(0)
+}
+
+func (le *lineEditor) end() {
+	le.moveTo(len(le.buf))
+}
+
+// set replaces the whole line with rs and moves the cursor to its end.
+func (le *lineEditor) set(rs []rune) {
+	le.replace(0, len(le.buf), rs, len(rs))
+}
+
+// reset empties the line, the terminal cursor stays where it is.
+func (le *lineEditor) reset() {
+	le.buf = nil
+	le.pos = 0
+}
+
+// moveTo moves the cursor to i.
+func (le *lineEditor) moveTo(i int) {
+	if i < le.pos {
+		fmt.Fprintf(le.out, "%s", strings.Repeat("\x08", runesWidth(le.buf[i:le.pos])))
+	} else {
+		fmt.Fprintf(le.out, "%s", displayRunes(le.buf[le.pos:i]))
+	}
+	le.pos = i
+}
+
+// replace replaces the runes between i and j with add, redraws the line from
+// the first changed character and moves the cursor to newpos.
+func (le *lineEditor) replace(i, j int, add []rune, newpos int) {
+	s := i
+	if le.pos < s {
+		s = le.pos
+	}
+	s = le.start(s)
+	if s == i && s > 0 && len(add) > 0 && combines(add[0]) {
+		// add[0] combines with the character before it
+		s = le.start(s - 1)
+	}
+	le.moveTo(s)
+	oldw := runesWidth(le.buf[s:])
+
+	buf := make([]rune, 0, len(le.buf)-(j-i)+len(add))
+	buf = append(buf, le.buf[:i]...)
+	buf = append(buf, add...)
+	le.buf = append(buf, le.buf[j:]...)
+
+	var out bytes.Buffer
+	out.WriteString(displayRunes(le.buf[s:]))
+	back := runesWidth(le.buf[newpos:])
+	if pad := oldw - runesWidth(le.buf[s:]); pad > 0 {
+		out.WriteString(strings.Repeat(" ", pad))
+		back += pad
+	}
+	out.WriteString(strings.Repeat("\x08", back))
+	fmt.Fprintf(le.out, "%s", out.String())
+	le.pos = newpos
+}
+
+// start returns the start of the character containing the rune at i, i.e.
+// the base character of a sequence of combining marks.
+func (le *lineEditor) start(i int) int {
+	for i > 0 && i < len(le.buf) && combines(le.buf[i]) {
+		i--
+	}
+	return i
+}
+
+// next returns the start of the character after the one at i.
+func (le *lineEditor) next(i int) int {
+	i++
+	for i < len(le.buf) && combines(le.buf[i]) {
+		i++
+	}
+	return i
+}
+
+// cellWidth returns the number of cells used to display r on the edited line.
+func cellWidth(r rune) int {
+	if r < 0x20 || r == 0x7f {
+		return len(displayRune(r))
+	}
+	return runeWidth(r)
+}
+
+func runesWidth(rs []rune) int {
+	w := 0
+	for _, r := range rs {
+		w += cellWidth(r)
+	}
+	return w
+}
+
+// displayRune returns how r is displayed on the edited line, control
+// characters are shown as ^Z, ^I, etc.
+func displayRune(r rune) string {
+	if r < 0x20 || r == 0x7f {
+		return string([]rune{'^', r ^ 0x40})
+	}
+	return string(r)
+}
+
+func displayRunes(rs []rune) string {
+	var out strings.Builder
+	for _, r := range rs {
+		out.WriteString(displayRune(r))
+	}
+	return out.String()
+}
+
+// keyRune returns the character typed with key, if key is a character
+// rather than an escape sequence.
+func keyRune(key []byte) (rune, bool) {
+	if len(key) == 0 || key[0] == 0x1b {
+		return 0, false
+	}
+	r, _ := utf8.DecodeRune(key)
+	return r, true
+}
+
+// isKey returns true if key is one of seqs.
+func isKey(key []byte, seqs ...[]byte) bool {
+	for _, seq := range seqs {
+		if bytes.Equal(key, seq) {
+			return true
+		}
+	}
+	return false
+}
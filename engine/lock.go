@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// LockPath returns the path of the lock file for Path, a hidden file next to
+// the target of Path. Path itself isn't locked because saving replaces it.
+func (e *Edlin) LockPath() string {
+	dir, base := filepath.Split(e.target())
+	return filepath.Join(dir, "."+base+".edlin-lock")
+}
+
+// Lock takes the lock that keeps other sessions from editing Path at the
+// same time. If another session holds it a *LockError naming its process
+// and user is returned.
+// The lock file contains the pid and the name of the user of the session
+// holding the lock.
+func (e *Edlin) Lock() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.defaults()
+	l, path, err := e.takeLock()
+	if err != nil {
+		return err
+	}
+	e.swapLock(l, path)
+	return nil
+}
+
+// takeLock takes the lock of Path, see Lock, and returns it with the path of
+// the lock file.
+func (e *Edlin) takeLock() (io.Closer, string, error) {
+	path := e.LockPath()
+	l, err := e.FS.Lock(path)
+	if err == ErrLocked {
+		lerr := &LockError{Path: e.Path}
+		if fh, err := e.FS.Open(path); err == nil {
+			fmt.Fscanf(bufio.NewReader(fh), "%d %s\n", &lerr.PID, &lerr.User)
+			fh.Close()
+		}
+		return nil, "", lerr
+	}
+	if err != nil {
+		return nil, "", &IOError{"lock", path, err}
+	}
+
+	name := strconv.Itoa(os.Getuid())
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	fh, err := e.FS.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err == nil {
+		_, err = fmt.Fprintf(fh, "%d %s\n", os.Getpid(), name)
+		if err1 := fh.Close(); err == nil {
+			err = err1
+		}
+	}
+	if err != nil {
+		l.Close()
+		return nil, "", &IOError{"lock", path, err}
+	}
+	return l, path, nil
+}
+
+// Unlock releases the lock taken by Lock and removes the lock file.
+// It doesn't wait for the command being executed, so it can be called from
+// any goroutine, also after EmergencySave.
+func (e *Edlin) Unlock() {
+	e.unlock()
+}
+
+func (e *Edlin) unlock() {
+	if l, path := e.swapLock(nil, ""); l != nil {
+		e.FS.Remove(path)
+		l.Close()
+	}
+}
+
+// swapLock replaces the lock held by the editor with l, whose lock file is
+// path, and returns the old one.
+func (e *Edlin) swapLock(l io.Closer, path string) (io.Closer, string) {
+	e.lockMu.Lock()
+	defer e.lockMu.Unlock()
+	oldLock, oldPath := e.lock, e.lockPath
+	e.lock, e.lockPath = l, path
+	return oldLock, oldPath
+}
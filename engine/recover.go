@@ -0,0 +1,390 @@
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The recovery file describes the file being edited as the concatenation
+// of:
+//
+//	the first <backup> bytes of the temporary file written by W
+//	the lines following the header (the buffer and the lines given back to
+//	the input file by undo)
+//	the input file, starting at <offset>
+//
+// The header is:
+//
+//	edlin-recover 4
+//	<temporary file path>
+//	<backup>
+//	<offset>
+//	<input file path>
+//	<line ending>
+//	<input file encoding>
+//	<encoding>
+//	<no final line ending>
+//	<^Z>
+//
+// This keeps it small: only the part of the file that is in memory is
+// written, the rest is still on disk. The lines in the temporary file and
+// in the recovery file are UTF-8 and end with LF, the input file keeps its
+// encoding and line endings. <line ending> and <encoding> are the ones used
+// to save the file, the last two are true or false and tell how the file
+// ends as far as the input file was read.
+const recoverMagic = "edlin-recover 4"
+
+// The recovery file is rewritten whole, so once it exists it is updated only
+// if recoverChanges changes were made or recoverInterval elapsed since it
+// was last written. EmergencySave writes it regardless.
+const (
+	recoverChanges  = 20
+	recoverInterval = 10 * time.Second
+)
+
+// ErrRecoverExists is returned by CheckRecover in batch mode.
+var ErrRecoverExists = errors.New("a recovery file exists, start edlin interactively to restore or discard it")
+
+// RecoverPath returns the path of the recovery file for Path, a hidden file
+// in the same directory.
+func (e *Edlin) RecoverPath() string {
+	return recoverPathOf(e.Path)
+}
+
+func recoverPathOf(path string) string {
+	dir, base := filepath.Split(path)
+	return filepath.Join(dir, "."+base+".edlin-recover")
+}
+
+// restoredPath returns the path of the file holding the contents of a
+// restored recovery file.
+func (e *Edlin) restoredPath() string {
+	return restoredPathOf(e.Path)
+}
+
+func restoredPathOf(path string) string {
+	dir, base := filepath.Split(path)
+	return filepath.Join(dir, "."+base+".edlin-restored")
+}
+
+// EmergencySave updates the recovery file, it is meant to be called when
+// the editor is about to be killed. Returns the path of the recovery file,
+// or an empty string if there were no changes to save or ReadOnly is set.
+// It can be called from any goroutine: it waits for the command being
+// executed to finish or to wait for a key, and from then on Exec, Input and
+// CheckRecover block so that nothing changes until the process exits.
+func (e *Edlin) EmergencySave() (string, error) {
+	e.mu.Lock()
+	e.defaults()
+	if !e.Dirty || e.ReadOnly {
+		// the recovery file belongs to the session holding the lock
+		return "", nil
+	}
+	if err := e.saveRecover(); err != nil {
+		return "", err
+	}
+	return e.RecoverPath(), nil
+}
+
+// updateRecover saves the recovery file if Recover is set and the buffer
+// changed since the last time it was saved, see recoverChanges. If force is
+// set the recovery file is saved after any change.
+func (e *Edlin) updateRecover(force bool) {
+	if !e.Recover || e.changeCount == e.recoverCount {
+		return
+	}
+	if !e.Dirty {
+		// the next change writes a new recovery file right away
+		e.recoverCount, e.recoverTime = e.changeCount, time.Time{}
+		e.FS.Remove(e.RecoverPath())
+		return
+	}
+	if !force && e.changeCount-e.recoverCount < recoverChanges && time.Since(e.recoverTime) < recoverInterval {
+		return
+	}
+	e.recoverCount, e.recoverTime = e.changeCount, time.Now()
+	if err := e.saveRecover(); err != nil {
+		fmt.Fprintf(e.Stdout, "Recovery file not updated: %v\n", err)
+	}
+}
+
+func (e *Edlin) saveRecover() error {
+	path := e.RecoverPath()
+	inputPath := e.inputPath
+	noEOL, ctrlZ := e.NoFinalEOL, e.CtrlZ
+	if e.input == nil {
+		inputPath = ""
+	} else {
+		noEOL, ctrlZ = e.inputSplit.noEOL, e.inputSplit.ctrlZ
+	}
+
+	fh, err := e.FS.OpenFile(path+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return &IOError{"write", path, err}
+	}
+	w := bufio.NewWriter(fh)
+	fmt.Fprintf(w, "%s\n%s\n%d\n%d\n%s\n%v\n%v\n%v\n%t\n%t\n", recoverMagic, e.tempPath, e.tempSize(), e.inputOffset, inputPath, e.EOL, e.inputEnc, e.Encoding, noEOL, ctrlZ)
+	for _, lines := range [][]string{e.Lines, e.pushback} {
+		for _, line := range lines {
+			w.WriteString(line)
+			w.WriteByte('\n')
+		}
+	}
+	err = w.Flush()
+	if err1 := fh.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = e.FS.Rename(path+".new", path)
+	}
+	if err != nil {
+		return &IOError{"write", path, err}
+	}
+	return nil
+}
+
+// recoverHeader is the header of the recovery file.
+type recoverHeader struct {
+	tempPath       string
+	backup, offset int64
+	inputPath      string
+	eol            EOL
+	inputEnc, enc  *Encoding
+
+	// end is how the file ends, openRecover updates it while the input
+	// file is read
+	end *lineSplitter
+}
+
+// readRecoverHeader reads the header of the recovery file from rd.
+func (e *Edlin) readRecoverHeader(rd *bufio.Reader) (recoverHeader, error) {
+	path := e.RecoverPath()
+	var hdr [10]string
+	for i := range hdr {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return recoverHeader{}, &IOError{"read", path, err}
+		}
+		hdr[i] = strings.TrimSuffix(line, "\n")
+	}
+	backup, err1 := strconv.ParseInt(hdr[2], 10, 64)
+	offset, err2 := strconv.ParseInt(hdr[3], 10, 64)
+	eol, ok1 := parseEOL(hdr[5])
+	inputEnc, ok2 := LookupEncoding(hdr[6])
+	enc, ok3 := LookupEncoding(hdr[7])
+	noEOL, err3 := strconv.ParseBool(hdr[8])
+	ctrlZ, err4 := strconv.ParseBool(hdr[9])
+	if hdr[0] != recoverMagic || err1 != nil || err2 != nil || !ok1 || !ok2 || !ok3 || err3 != nil || err4 != nil {
+		return recoverHeader{}, &IOError{"read", path, errors.New("not a recovery file")}
+	}
+	end := newLineSplitter(inputEnc, !e.Binary)
+	end.noEOL, end.ctrlZ = noEOL, ctrlZ
+	return recoverHeader{hdr[1], backup, offset, hdr[4], eol, inputEnc, enc, end}, nil
+}
+
+// openRecover returns the header of the recovery file and the contents of
+// the file it describes, as UTF-8 lines ending with LF.
+func (e *Edlin) openRecover() (recoverHeader, io.ReadCloser, error) {
+	path := e.RecoverPath()
+	fh, err := e.FS.Open(path)
+	if err != nil {
+		return recoverHeader{}, nil, &IOError{"read", path, err}
+	}
+	rc := &multiReadCloser{closers: []io.Closer{fh}}
+	rd := bufio.NewReader(fh)
+	hdr, err := e.readRecoverHeader(rd)
+	if err != nil {
+		rc.Close()
+		return hdr, nil, err
+	}
+
+	var readers []io.Reader
+	if hdr.backup > 0 {
+		tmp, err := e.FS.Open(hdr.tempPath)
+		if err != nil {
+			rc.Close()
+			return hdr, nil, &IOError{"read", hdr.tempPath, err}
+		}
+		rc.closers = append(rc.closers, tmp)
+		readers = append(readers, io.LimitReader(tmp, hdr.backup))
+	}
+	readers = append(readers, rd)
+	if hdr.inputPath != "" {
+		in, err := e.FS.Open(hdr.inputPath)
+		if err != nil {
+			rc.Close()
+			return hdr, nil, &IOError{"read", hdr.inputPath, err}
+		}
+		rc.closers = append(rc.closers, in)
+		if err := skip(in, hdr.offset); err != nil {
+			rc.Close()
+			return hdr, nil, &IOError{"read", hdr.inputPath, err}
+		}
+		readers = append(readers, newDecodedReader(in, hdr.end))
+	}
+	rc.Reader = io.MultiReader(readers...)
+	return hdr, rc, nil
+}
+
+type multiReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (rc *multiReadCloser) Close() error {
+	for _, c := range rc.closers {
+		c.Close()
+	}
+	return nil
+}
+
+// CheckRecover looks for a recovery file left by a previous session and
+// asks the user whether to restore it, discard it or see how it differs
+// from the file on disk. It must be called before loading the file, returns
+// true if the recovered file was loaded.
+func (e *Edlin) CheckRecover() (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.defaults()
+	path := e.RecoverPath()
+	if _, err := e.FS.Stat(path); err != nil {
+		return false, nil
+	}
+	if e.Batch {
+		return false, &IOError{"recover", path, ErrRecoverExists}
+	}
+
+	fmt.Fprintf(e.Stdout, "Recovery file found for %s\n", e.Path)
+	for {
+		key, err := e.choose("Restore, Discard or Show differences (R/D/S)? ", "RDS")
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(e.Stdout, "\n")
+		switch key {
+		case 'R':
+			return true, e.restore()
+		case 'D':
+			if fh, err := e.FS.Open(path); err == nil {
+				hdr, err := e.readRecoverHeader(bufio.NewReader(fh))
+				fh.Close()
+				if err == nil && hdr.tempPath != "" {
+					e.FS.Remove(hdr.tempPath)
+				}
+			}
+			e.removeRecover()
+			return false, nil
+		case 'S':
+			if err := e.diffRecover(); err != nil {
+				return false, err
+			}
+		}
+	}
+}
+
+// restore copies the file described by the recovery file to restoredPath,
+// ending all lines the same way and the file as it ended, and loads it.
+func (e *Edlin) restore() error {
+	hdr, src, err := e.openRecover()
+	if err != nil {
+		return err
+	}
+	dst := e.restoredPath()
+	fh, err := e.FS.OpenFile(dst+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		src.Close()
+		return &IOError{"write", dst, err}
+	}
+	w := bufio.NewWriter(fh)
+	eol := hdr.eol.seq()
+	lineno := 0
+	err = forEachFileLine(src, e.RecoverPath(), newLineSplitter(nil, false), func(line string) error {
+		if lineno > 0 {
+			w.WriteString(eol)
+		}
+		lineno++
+		_, err := w.WriteString(line)
+		return err
+	})
+	if lineno > 0 && !hdr.end.noEOL {
+		w.WriteString(eol)
+	}
+	if hdr.end.ctrlZ {
+		w.WriteString("\x1a")
+	}
+	if err1 := w.Flush(); err == nil {
+		err = err1
+	}
+	if err1 := fh.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = e.FS.Rename(dst+".new", dst)
+	}
+	if _, ok := err.(*IOError); ok {
+		return err
+	}
+	if err != nil {
+		return &IOError{"write", dst, err}
+	}
+	if hdr.tempPath != "" {
+		e.FS.Remove(hdr.tempPath)
+	}
+
+	in, err := e.FS.Open(dst)
+	if err != nil {
+		return &IOError{"read", dst, err}
+	}
+	e.inputPath = dst
+	e.EOL = hdr.eol
+	e.Encoding = UTF8
+	if err := e.Load(in); err != nil {
+		return err
+	}
+	e.Encoding = hdr.enc
+	e.Dirty = true
+	e.changeCount++
+	e.updateRecover(true)
+	return nil
+}
+
+// diffRecover shows the differences between the file on disk and the
+// recovery file.
+func (e *Edlin) diffRecover() error {
+	hdr, fh, err := e.openRecover()
+	if err != nil {
+		return err
+	}
+	disk, err := e.diskLines(hdr.enc)
+	if err != nil {
+		fh.Close()
+		return err
+	}
+	recovered := newDiffInput(e.maxBytes())
+	if err := forEachFileLine(fh, e.RecoverPath(), newLineSplitter(nil, false), recovered.add); err != nil {
+		return err
+	}
+	if !writeDiffInputs(e.Stdout, e.Path, e.RecoverPath(), disk, recovered) {
+		fmt.Fprintf(e.Stdout, "No differences\n")
+	}
+	return nil
+}
+
+// removeRecover removes the recovery file and the restored file, at the end
+// of the session.
+func (e *Edlin) removeRecover() {
+	e.removeRecoverOf(e.Path)
+}
+
+// removeRecoverOf removes the recovery file and the restored file of path.
+func (e *Edlin) removeRecoverOf(path string) {
+	e.FS.Remove(recoverPathOf(path))
+	e.FS.Remove(restoredPathOf(path))
+}
@@ -0,0 +1,613 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// target returns the file that is actually written when saving Path, i.e.
+// Path with all symbolic links resolved.
+func (e *Edlin) target() string {
+	if target, err := e.FS.EvalSymlinks(e.Path); err == nil {
+		return target
+	}
+	return e.Path
+}
+
+// openTemp returns the temporary file where W writes lines, creating it if
+// needed. It is created with O_EXCL and a random name, next to the target
+// of Path so that it can be renamed over it.
+func (e *Edlin) openTemp() (io.WriteCloser, error) {
+	if e.tempPath != "" {
+		fh, err := e.FS.OpenFile(e.tempPath, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, &IOError{"write", e.tempPath, err}
+		}
+		return fh, nil
+	}
+	path, fh, err := e.createTemp()
+	if err != nil {
+		return nil, err
+	}
+	e.tempPath = path
+	return fh, nil
+}
+
+// createTemp creates a new temporary file next to the target of Path.
+func (e *Edlin) createTemp() (string, io.WriteCloser, error) {
+	dir, base := filepath.Split(e.target())
+	var rnd [6]byte
+	for tries := 0; ; tries++ {
+		if _, err := rand.Read(rnd[:]); err != nil {
+			return "", nil, &IOError{"write", e.Path, err}
+		}
+		path := filepath.Join(dir, "."+base+".edlin-"+hex.EncodeToString(rnd[:]))
+		fh, err := e.FS.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0600)
+		if err == nil {
+			return path, fh, nil
+		}
+		if !os.IsExist(err) || tries >= 100 {
+			return "", nil, &IOError{"write", path, err}
+		}
+	}
+}
+
+// tempSize returns the size of the temporary file.
+func (e *Edlin) tempSize() int64 {
+	if e.tempPath == "" {
+		return 0
+	}
+	fi, err := e.FS.Stat(e.tempPath)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// removeTemp removes the temporary file.
+func (e *Edlin) removeTemp() {
+	if e.tempPath != "" {
+		e.FS.Remove(e.tempPath)
+		e.tempPath = ""
+	}
+}
+
+// replaceFile replaces the target of Path with the temporary file tmp, which
+// is removed. The temporary file is given the permissions, owner and
+// extended attributes of the target, flushed to disk and renamed over it. If
+// the target has other hard links, or its owner can't be copied, it is
+// rewritten in place instead.
+func (e *Edlin) replaceFile(tmp string) error {
+	target := e.target()
+	fi, err := e.FS.Stat(target)
+	if os.IsNotExist(err) {
+		// create it to let the operating system pick its permissions
+		var fh io.WriteCloser
+		fh, err = e.FS.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			fh.Close()
+			fi, err = e.FS.Stat(target)
+		}
+	}
+	if err != nil {
+		return &IOError{"save", target, err}
+	}
+	if err := e.FS.Sync(tmp); err != nil {
+		return &IOError{"save", tmp, err}
+	}
+
+	if nlink(fi) > 1 || e.FS.CopyMetadata(target, tmp) != nil {
+		return e.rewriteFile(target, tmp)
+	}
+	if err := e.FS.Rename(tmp, target); err != nil {
+		return &IOError{"save", target, err}
+	}
+	if err := e.FS.Sync(filepath.Dir(target)); err != nil {
+		return &IOError{"save", filepath.Dir(target), err}
+	}
+	return nil
+}
+
+// rewriteFile copies the temporary file tmp over target, without replacing
+// it.
+func (e *Edlin) rewriteFile(target, tmp string) error {
+	src, err := e.FS.Open(tmp)
+	if err != nil {
+		return &IOError{"save", tmp, err}
+	}
+	defer src.Close()
+	dst, err := e.FS.OpenFile(target, os.O_WRONLY, 0)
+	if err != nil {
+		return &IOError{"save", target, err}
+	}
+	n, err := io.Copy(dst, src)
+	if err1 := dst.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = e.FS.Truncate(target, n)
+	}
+	if err == nil {
+		err = e.FS.Sync(target)
+	}
+	if err != nil {
+		return &IOError{"save", target, err}
+	}
+	e.FS.Remove(tmp)
+	return nil
+}
+
+// nlink returns the number of hard links to the file described by fi.
+func nlink(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
+
+// fileStamp describes the contents of Path as they were when it was loaded
+// or saved, to find out whether someone else changed it.
+type fileStamp struct {
+	size  int64
+	mtime time.Time
+	hash  []byte
+}
+
+// stampFile returns the stamp of path, a file that doesn't exist has the
+// same stamp as an empty file.
+func (e *Edlin) stampFile(path string) (fileStamp, error) {
+	fi, err := e.FS.Stat(path)
+	if os.IsNotExist(err) {
+		return fileStamp{}, nil
+	}
+	if err != nil {
+		return fileStamp{}, err
+	}
+	h, err := e.hashFile(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{fi.Size(), fi.ModTime(), h}, nil
+}
+
+// hashFile returns the hash of the contents of path.
+func (e *Edlin) hashFile(path string) ([]byte, error) {
+	fh, err := e.FS.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, fh); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// diskChanged returns true if Path is different from stamp. The contents are
+// only compared if the size is the same and the modification time isn't.
+// If Path can't be read it is reported as unchanged, saving it will fail
+// with a better error.
+func (e *Edlin) diskChanged() bool {
+	fi, err := e.FS.Stat(e.Path)
+	if os.IsNotExist(err) {
+		return e.stamp.size != 0
+	}
+	if err != nil {
+		return false
+	}
+	switch {
+	case fi.Size() != e.stamp.size:
+		return true
+	case fi.Size() == 0 || fi.ModTime().Equal(e.stamp.mtime):
+		return false
+	}
+	h, err := e.hashFile(e.Path)
+	if err != nil {
+		return false
+	}
+	return !bytes.Equal(h, e.stamp.hash)
+}
+
+// checkSave is called before saving, it fails if ReadOnly is set. If Path
+// was changed by someone else since it was loaded or saved it asks the user
+// whether to overwrite it, abort or see how it differs from the buffer.
+func (e *Edlin) checkSave() error {
+	if e.ReadOnly {
+		return ErrReadOnly
+	}
+	if !e.diskChanged() {
+		return nil
+	}
+	fmt.Fprintf(e.Stdout, "%s changed on disk\n", e.Path)
+	for {
+		key, err := e.choose("Overwrite, Abort or Show differences (O/A/S)? ", "OAS")
+		if err != nil {
+			return err
+		}
+		switch key {
+		case 'O':
+			// don't ask again until someone changes it again
+			e.stamp, _ = e.stampFile(e.Path)
+			return nil
+		case 'S':
+			if err := e.diffDisk(); err != nil {
+				return err
+			}
+		default:
+			return ErrChanged
+		}
+	}
+}
+
+// diffDisk shows the differences between the file on disk and the buffer.
+func (e *Edlin) diffDisk() error {
+	disk, err := e.diskLines(e.Encoding)
+	if err != nil {
+		return err
+	}
+	buffer := newDiffInput(e.maxBytes())
+	if err := e.forEachLine(buffer.add, nil); err != nil {
+		return err
+	}
+	if !writeDiffInputs(e.Stdout, e.Path, "(buffer)", disk, buffer) {
+		fmt.Fprintf(e.Stdout, "No differences\n")
+	}
+	return nil
+}
+
+// diskLines reads the lines of Path to compare them with something else, enc
+// is its encoding if it has no byte order mark. A missing file is empty.
+func (e *Edlin) diskLines(enc *Encoding) (*diffInput, error) {
+	disk := newDiffInput(e.maxBytes())
+	fh, enc, err := e.openText(e.Path, enc)
+	if err != nil {
+		return disk, nil
+	}
+	return disk, forEachFileLine(fh, e.Path, newLineSplitter(enc, !e.Binary), disk.add)
+}
+
+// forEachLine calls fn for each line of the file being edited: the lines in
+// the temporary file, the buffer, the lines given back to the input file by
+// undo and the unread part of the input file. If input isn't nil it is
+// called before the first line of the input file. Reading the input file
+// to the end records how the file ends, see inputEnded.
+func (e *Edlin) forEachLine(fn func(string) error, input func()) error {
+	if e.tempPath != "" {
+		tmp, err := e.FS.Open(e.tempPath)
+		if err != nil {
+			return &IOError{"read", e.tempPath, err}
+		}
+		if err := forEachFileLine(tmp, e.tempPath, newLineSplitter(nil, false), fn); err != nil {
+			return err
+		}
+	}
+	for _, lines := range [][]string{e.Lines, e.pushback} {
+		for _, line := range lines {
+			if err := fn(line); err != nil {
+				return err
+			}
+		}
+	}
+	if input != nil {
+		input()
+	}
+	if e.input == nil {
+		return nil
+	}
+	in, err := e.unreadInput()
+	if err != nil {
+		return err
+	}
+	// carry on from where input stopped, the end of the file is only known
+	// after reading all of it
+	ls := *e.inputSplit
+	ls.counts = nil
+	if err := forEachFileLine(in, e.inputPath, &ls, fn); err != nil {
+		return err
+	}
+	e.inputEnded(&ls)
+	return nil
+}
+
+// unreadInput returns the part of the input file not read yet, if possible
+// from the file already open so that it doesn't matter if the path was
+// replaced in the meantime.
+func (e *Edlin) unreadInput() (io.ReadCloser, error) {
+	if ra, ok := e.inputfh.(io.ReaderAt); ok {
+		return ioutil.NopCloser(io.NewSectionReader(ra, e.inputOffset, 1<<63-1)), nil
+	}
+	in, err := e.FS.Open(e.inputPath)
+	if err != nil {
+		return nil, &IOError{"read", e.inputPath, err}
+	}
+	if err := skip(in, e.inputOffset); err != nil {
+		in.Close()
+		return nil, &IOError{"read", e.inputPath, err}
+	}
+	return in, nil
+}
+
+// save writes the whole file to Path, encoded in Encoding and ending every
+// line with EOL (but the last one if NoFinalEOL is set) followed by ^Z if
+// CtrlZ is set, without ending the session. The unread part of the input
+// file is read from the saved file from then on.
+// If some lines can't be encoded nothing is saved and an *EncodingError
+// lists them.
+func (e *Edlin) save(params []int) error {
+	if len(params) != 0 {
+		return ErrEntry
+	}
+	if err := e.checkSave(); err != nil {
+		return err
+	}
+	tmp, fh, err := e.createTemp()
+	if err != nil {
+		return err
+	}
+	h := sha1.New()
+	cw := &countWriter{w: io.MultiWriter(fh, h)}
+	w := bufio.NewWriter(cw)
+	var offset int64
+	enc := e.Encoding
+	eol, _ := enc.encode(nil, e.EOL.seq())
+	if enc != nil {
+		w.Write(enc.bom)
+	}
+	var buf []byte
+	lineno, inputLine := 0, 0
+	encErr := &EncodingError{Encoding: enc.String()}
+	err = e.forEachLine(func(line string) error {
+		if lineno > 0 {
+			// the line ending of the last line depends on how the file
+			// ends, which is only known at the end
+			w.Write(eol)
+		}
+		lineno++
+		var ok bool
+		buf, ok = enc.encode(buf[:0], line)
+		if !ok {
+			encErr.add(lineno)
+		}
+		_, err := w.Write(buf)
+		return err
+	}, func() {
+		w.Flush()
+		offset = cw.n
+		inputLine = lineno
+	})
+	if lineno > 0 && !e.NoFinalEOL {
+		w.Write(eol)
+	}
+	if e.CtrlZ {
+		w.Write(enc.ctrlZ())
+	}
+	if err1 := w.Flush(); err == nil {
+		err = err1
+	}
+	if err1 := fh.Close(); err == nil {
+		err = err1
+	}
+	if err == nil && encErr.Count > 0 {
+		e.FS.Remove(tmp)
+		return encErr
+	}
+	if err != nil {
+		e.FS.Remove(tmp)
+		if _, ok := err.(*IOError); ok {
+			return err
+		}
+		return &IOError{"write", tmp, err}
+	}
+	if err := e.replaceFile(tmp); err != nil {
+		e.FS.Remove(tmp)
+		return err
+	}
+	e.restamp(h)
+
+	if e.input != nil && lineno == inputLine {
+		// nothing was left to read
+		e.closeInput()
+	}
+	if e.input != nil {
+		if inputLine > 0 {
+			offset += int64(len(eol))
+		}
+		in, err := e.FS.Open(e.Path)
+		if err != nil {
+			return &IOError{"read", e.Path, err}
+		}
+		if err := skip(in, offset); err != nil {
+			in.Close()
+			return &IOError{"read", e.Path, err}
+		}
+		e.closeInput()
+		e.inputPath = e.Path
+		e.setInput(in, in, offset, enc)
+		// the rest of the file was saved with EOL
+		e.inputEOLs = eolCounts{}
+	}
+
+	e.Dirty = false
+	for i := range e.undos {
+		// undoing anything makes the buffer different from the saved file
+		e.undos[i].dirty = true
+	}
+	e.removeRecover()
+	return nil
+}
+
+// saveAs saves the whole file to path, like save, and keeps editing it
+// instead of Path. If the session holds a lock (or ReadOnly is set because
+// someone else holds it) path is locked and the lock of Path released.
+func (e *Edlin) saveAs(params []int, path string) error {
+	if len(params) != 0 {
+		return ErrEntry
+	}
+	if e.sameFile(path, e.Path) {
+		return e.save(params)
+	}
+	if _, err := e.FS.Stat(path); err == nil {
+		key, err := e.yesno(fmt.Sprintf("%s exists, overwrite (Y/N)? ", path), true)
+		if err != nil || key != 'Y' {
+			return err
+		}
+	}
+
+	oldPath, oldStamp, oldReadOnly := e.Path, e.stamp, e.ReadOnly
+	e.lockMu.Lock()
+	locked := e.lock != nil
+	e.lockMu.Unlock()
+	e.Path = path
+	e.stamp, _ = e.stampFile(path)
+	var oldLock io.Closer
+	var oldLockPath string
+	if locked || oldReadOnly {
+		l, lockPath, err := e.takeLock()
+		if err != nil {
+			e.Path, e.stamp = oldPath, oldStamp
+			return err
+		}
+		oldLock, oldLockPath = e.swapLock(l, lockPath)
+		e.ReadOnly = false
+	}
+	if err := e.save(params); err != nil {
+		if locked || oldReadOnly {
+			if l, lockPath := e.swapLock(oldLock, oldLockPath); l != nil {
+				e.FS.Remove(lockPath)
+				l.Close()
+			}
+		}
+		e.Path, e.stamp, e.ReadOnly = oldPath, oldStamp, oldReadOnly
+		return err
+	}
+	if oldLock != nil {
+		e.FS.Remove(oldLockPath)
+		oldLock.Close()
+	}
+	e.removeRecoverOf(oldPath)
+	return nil
+}
+
+// sameFile returns true if a and b are the same file.
+func (e *Edlin) sameFile(a, b string) bool {
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+	fia, erra := e.FS.Stat(a)
+	fib, errb := e.FS.Stat(b)
+	return erra == nil && errb == nil && os.SameFile(fia, fib)
+}
+
+// export writes lines of the buffer to a file, by default all of them, rest
+// is the path of the file, preceded by + to append to it instead of
+// replacing it. It can't be Path, which is saved with F. The lines
+// are encoded in Encoding and end with EOL, if some of them can't be
+// encoded nothing is written and an *EncodingError lists them.
+func (e *Edlin) export(params []int, rest string) error {
+	p0, p1, err := params2(params)
+	if err != nil {
+		return err
+	}
+	if p0 == 0 {
+		p0 = 1
+	}
+	if p1 == 0 {
+		p1 = p0
+	}
+	if p1 == len(e.Lines)+1 && p0 < p1 {
+		p1 = len(e.Lines)
+	}
+	if len(params) == 0 {
+		// the whole buffer, even if it's empty
+		p0, p1 = 1, len(e.Lines)
+	} else if p0 > p1 || p1 > len(e.Lines) {
+		return ErrEntry
+	}
+	rest = strings.TrimSpace(rest)
+	appending := strings.HasPrefix(rest, "+")
+	path := strings.TrimSpace(strings.TrimPrefix(rest, "+"))
+	if path == "" {
+		return ErrEntry
+	}
+	if e.sameFile(path, e.Path) {
+		// the unread part of the file would be lost
+		return ErrSameFile
+	}
+
+	enc := e.Encoding
+	eol, _ := enc.encode(nil, e.EOL.seq())
+	var buf []byte
+	encErr := &EncodingError{Encoding: enc.String()}
+	for i := p0; i <= p1; i++ {
+		var ok bool
+		if buf, ok = enc.encode(buf, e.Lines[i-1]); !ok {
+			encErr.add(i)
+		}
+		buf = append(buf, eol...)
+	}
+	if encErr.Count > 0 {
+		return encErr
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if fi, err := e.FS.Stat(path); err == nil && fi.Size() > 0 {
+			// the byte order mark is only at the start of the file
+			enc = nil
+		}
+	}
+	fh, err := e.FS.OpenFile(path, flag, 0666)
+	if err != nil {
+		return &IOError{"write", path, err}
+	}
+	if enc != nil {
+		_, err = fh.Write(enc.bom)
+	}
+	if err == nil {
+		_, err = fh.Write(buf)
+	}
+	if err1 := fh.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return &IOError{"write", path, err}
+	}
+	return nil
+}
+
+// restamp records the stamp of Path after saving it, h is the hash of what
+// was written.
+func (e *Edlin) restamp(h hash.Hash) {
+	fi, err := e.FS.Stat(e.Path)
+	if err != nil {
+		e.stamp = fileStamp{}
+		return
+	}
+	e.stamp = fileStamp{fi.Size(), fi.ModTime(), h.Sum(nil)}
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,243 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/pkg/term/termios"
+	"golang.org/x/sys/unix"
+)
+
+// Terminal is the device used by Edlin to interact with the user.
+type Terminal interface {
+	// Raw switches the terminal to raw mode and returns a function that
+	// switches it back.
+	Raw() (restore func())
+	// ReadKey returns the next key pressed by the user, escape sequences
+	// are returned whole. The returned slice is only valid until the next
+	// call.
+	ReadKey() ([]byte, error)
+	// ReadLine returns the bytes up to the next newline, which isn't
+	// included, as they are: it is used in batch mode. Returns io.EOF only
+	// if there is nothing left to read.
+	ReadLine() ([]byte, error)
+	Write(buf []byte) (int, error)
+	// Size returns the number of columns and rows of the terminal.
+	Size() (cols, rows int, err error)
+}
+
+var (
+	escSeqDelete = []byte{0x1b, 0x5b, 0x33, 0x7e}
+	escSeqInsert = []byte{0x1b, 0x5b, 0x32, 0x7e}
+	escSeqHome   = []byte{0x1b, 0x5b, 0x37, 0x7e}
+	escSeqEnd    = []byte{0x1b, 0x5b, 0x38, 0x7e}
+	escSeqF1     = []byte{0x1b, 0x5b, 0x31, 0x31, 0x7e}
+	escSeqF2     = []byte{0x1b, 0x5b, 0x31, 0x32, 0x7e}
+	escSeqF3     = []byte{0x1b, 0x5b, 0x31, 0x33, 0x7e}
+	escSeqF4     = []byte{0x1b, 0x5b, 0x31, 0x34, 0x7e}
+	escSeqF5     = []byte{0x1b, 0x5b, 0x31, 0x35, 0x7e}
+	escSeqLeft   = []byte{0x1b, 0x5b, 0x44}
+	escSeqRight  = []byte{0x1b, 0x5b, 0x43}
+	escSeqUp     = []byte{0x1b, 0x5b, 0x41}
+	escSeqDown   = []byte{0x1b, 0x5b, 0x42}
+
+	// alternative sequences sent by xterm and vt220 style terminals
+	escSeqHomeXterm = []byte{0x1b, 0x5b, 0x48}
+	escSeqEndXterm  = []byte{0x1b, 0x5b, 0x46}
+	escSeqHomeVT    = []byte{0x1b, 0x5b, 0x31, 0x7e}
+	escSeqEndVT     = []byte{0x1b, 0x5b, 0x34, 0x7e}
+)
+
+// keyReader splits a stream of bytes into keys, a key is either an escape
+// sequence or a single UTF-8 encoded character.
+type keyReader struct {
+	rd     io.Reader
+	buf    []byte
+	escbuf []byte
+	b      [1]byte
+	unread bool // b is the next byte, it was read too far by readRune
+}
+
+func newKeyReader(rd io.Reader) *keyReader {
+	return &keyReader{rd: rd, buf: make([]byte, 0, utf8.UTFMax), escbuf: make([]byte, 0, 10)}
+}
+
+// readByte returns the next byte of the stream.
+func (kr *keyReader) readByte() (byte, error) {
+	if kr.unread {
+		kr.unread = false
+		return kr.b[0], nil
+	}
+	if _, err := io.ReadFull(kr.rd, kr.b[:]); err != nil {
+		return 0, err
+	}
+	return kr.b[0], nil
+}
+
+func (kr *keyReader) Next() ([]byte, error) {
+	for {
+		c, err := kr.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch len(kr.escbuf) {
+		case 0:
+			if c == 0x1b { // ESC
+				kr.escbuf = append(kr.escbuf, 0x1b)
+			} else {
+				return kr.readRune(c)
+			}
+
+		case 1:
+			kr.escbuf = append(kr.escbuf, c)
+			if c != '[' {
+				// not a CSI
+				b := kr.escbuf
+				kr.escbuf = kr.escbuf[:0]
+				return b, nil
+			}
+
+		default:
+			kr.escbuf = append(kr.escbuf, c)
+			switch {
+			case (c >= 0x20 && c <= 0x2f) || (c >= 0x30 && c <= 0x3f):
+				// parameter or intermediate bytes
+			default:
+				// malformed sequence, flushing
+				fallthrough
+			case c >= 0x40 && c <= 0x7e:
+				// final character
+				b := kr.escbuf
+				kr.escbuf = kr.escbuf[:0]
+				return b, nil
+			}
+		}
+	}
+}
+
+// readRune reads the rest of the UTF-8 sequence started by c. A byte that
+// isn't a continuation byte ends an invalid sequence early and is left for
+// the next key.
+func (kr *keyReader) readRune(c byte) ([]byte, error) {
+	b := append(kr.buf[:0], c)
+	for n := utf8Len(c); len(b) < n; {
+		c, err := kr.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if c&0xc0 != 0x80 {
+			kr.unread = true
+			break
+		}
+		b = append(b, c)
+	}
+	return b, nil
+}
+
+// readLine reads the bytes up to the next newline, for ReadLine.
+func (kr *keyReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		c, err := kr.readByte()
+		if err == io.EOF && len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c == '\n' {
+			return line, nil
+		}
+		line = append(line, c)
+	}
+}
+
+// TermiosTerminal is a Terminal backed by a tty, if the input file isn't a
+// tty Raw does nothing.
+type TermiosTerminal struct {
+	in, out *os.File
+	keys    *keyReader
+}
+
+func NewTermiosTerminal(in, out *os.File) *TermiosTerminal {
+	return &TermiosTerminal{in, out, newKeyReader(bufio.NewReader(in))}
+}
+
+// IsTerminal returns true if the input file is a tty.
+func (t *TermiosTerminal) IsTerminal() bool {
+	var a syscall.Termios
+	return termios.Tcgetattr(t.in.Fd(), &a) == nil
+}
+
+func (t *TermiosTerminal) Raw() func() {
+	var a syscall.Termios
+	if err := termios.Tcgetattr(t.in.Fd(), &a); err == nil {
+		oldattr := a
+		termios.Cfmakeraw(&a)
+		termios.Tcsetattr(t.in.Fd(), termios.TCSANOW, &a)
+		return func() {
+			termios.Tcsetattr(t.in.Fd(), termios.TCSANOW, &oldattr)
+		}
+	}
+	return func() {}
+}
+
+func (t *TermiosTerminal) ReadKey() ([]byte, error) {
+	return t.keys.Next()
+}
+
+func (t *TermiosTerminal) ReadLine() ([]byte, error) {
+	return t.keys.readLine()
+}
+
+func (t *TermiosTerminal) Write(buf []byte) (int, error) {
+	return t.out.Write(buf)
+}
+
+func (t *TermiosTerminal) Size() (cols, rows int, err error) {
+	ws, err := unix.IoctlGetWinsize(int(t.out.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
+
+// ScriptTerminal is a Terminal that reads keys from a script and records
+// everything written to it in Output.
+type ScriptTerminal struct {
+	Output     bytes.Buffer
+	Cols, Rows int
+	keys       *keyReader
+}
+
+// NewScriptTerminal returns a 80x24 ScriptTerminal that will play back the
+// keys in script.
+func NewScriptTerminal(script string) *ScriptTerminal {
+	return &ScriptTerminal{Cols: 80, Rows: 24, keys: newKeyReader(strings.NewReader(script))}
+}
+
+func (t *ScriptTerminal) Raw() func() {
+	return func() {}
+}
+
+func (t *ScriptTerminal) ReadKey() ([]byte, error) {
+	return t.keys.Next()
+}
+
+func (t *ScriptTerminal) ReadLine() ([]byte, error) {
+	return t.keys.readLine()
+}
+
+func (t *ScriptTerminal) Write(buf []byte) (int, error) {
+	return t.Output.Write(buf)
+}
+
+func (t *ScriptTerminal) Size() (cols, rows int, err error) {
+	return t.Cols, t.Rows, nil
+}
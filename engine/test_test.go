@@ -0,0 +1,1367 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/sys/unix"
+)
+
+const vispaTeresa = `La vispa Teresa
+avea tra l'erbetta
+A volo sorpresa
+gentil farfalletta
+E tutta giuliva
+stringendola viva
+gridava a distesa:
+“L'ho presa! L'ho presa!”.
+A lei supplicando
+l'afflitta gridò:
+“Vivendo, volando
+che male ti fò?
+Tu sì mi fai male
+stringendomi l'ale!
+Deh, lasciami! Anch'io
+son figlia di Dio!”.
+Teresa pentita
+allenta le dita:
+“Va', torna all'erbetta,
+gentil farfalletta”.
+Confusa, pentita,
+Teresa arrossì,
+dischiuse le dita
+e quella fuggì
+`
+
+func testCommandIntl(t *testing.T, before, after string, cur int, command string, output string) (*Edlin, string) {
+	var e Edlin
+	var out bytes.Buffer
+	e.Term = NewScriptTerminal("")
+	e.Stdout = &out
+	e.Current = cur
+	if before != "" {
+		e.Lines = strings.Split(before, "\n")
+		if len(e.Lines) > 0 && e.Lines[len(e.Lines)-1] == "" {
+			e.Lines = e.Lines[:len(e.Lines)-1]
+		}
+	}
+	e.Exec(command)
+	t.Logf("<%s> -> %s\n", command, out.String())
+	if output != "*" && out.String() != output {
+		t.Errorf("error executing %q, output mismatch", command)
+	}
+	oafter := strings.Join(e.Lines, "\n")
+	if len(oafter) > 0 && oafter[len(oafter)-1] != '\n' {
+		oafter += "\n"
+	}
+	if after != oafter {
+		t.Errorf("buffer mismatch after executing %q: %q", command, oafter)
+	}
+	return &e, out.String()
+}
+
+func testCommand(t *testing.T, before, after string, cur int, command string, output string) (e *Edlin, o string) {
+	t.Run(command, func(t *testing.T) {
+		e, o = testCommandIntl(t, before, after, cur, command, output)
+	})
+	return e, o
+}
+
+func testList(t *testing.T, before, command string, start, end, cur int) {
+	listLineno := func(line string) int {
+		n, _ := strconv.Atoi(strings.TrimSpace(strings.SplitN(line, ":", 2)[0]))
+		return n
+	}
+
+	t.Run(command, func(t *testing.T) {
+		_, out := testCommandIntl(t, before, before, cur, command, "*")
+		v := strings.Split(out, "\n")
+		for i := len(v) - 1; i >= 0; i-- {
+			if v[i] != "" {
+				break
+			}
+			v = v[:i]
+		}
+		if n := listLineno(v[0]); n != start {
+			t.Errorf("wrong start line number %d", n)
+		}
+		if n := listLineno(v[len(v)-1]); n != end {
+			t.Errorf("wrong end line number %d", n)
+		}
+		for i := range v {
+			if strings.SplitN(v[i], ":", 2)[1][0] == '*' {
+				if curlineno := listLineno(v[i]); curlineno != cur {
+					t.Errorf("wrong current line number %d", curlineno)
+				}
+				break
+			}
+		}
+	})
+}
+
+func TestList(t *testing.T) {
+	testList(t, vispaTeresa, "l", 1, 23, 1)
+	testList(t, vispaTeresa, "1,l", 1, 23, 1)
+	testCommand(t, vispaTeresa, vispaTeresa, 1, "2,1l", ErrEntry.Error()+"\n")
+	testList(t, vispaTeresa, "2l", 2, 24, 1)
+	testList(t, vispaTeresa, "2,l", 2, 24, 1)
+	testList(t, vispaTeresa, "2,2l", 2, 2, 1)
+	testCommand(t, "", "", 1, "l", "")
+	testList(t, vispaTeresa, ",5l", 1, 5, 1)
+	testList(t, vispaTeresa, ",5l", 1, 5, 3)
+	testList(t, vispaTeresa, ",5l", 9, 24, 20)
+}
+
+func TestSearch(t *testing.T) {
+	testCommandIntl(t, vispaTeresa, vispaTeresa, 1, "ser", "*")
+}
+
+func TestSearchAddress(t *testing.T) {
+	const before = "func main() {\n\tx := 1\n}\n\nfunc f() {\n\treturn\n}\n"
+	testCommand(t, before, "\nfunc f() {\n\treturn\n}\n", 1, "/main/,/~^}/d", "*")
+	testCommand(t, before, "func main() {\n\tx := 1\n}\n\nfunc f() {\n}\n", 1, "/f()/+1d", "*")
+	testCommand(t, before, "func main() {\n\tx := 1\n}\n\n}\n", 7, "\\func\\,/return/d", "*")
+	testCommand(t, before, before, 2, "/func/-1,/func/l", "      4: \n      5: func f() {\n")
+	testCommand(t, before, before, 1, "/nothing/d", ErrNotFound.Error()+"\n")
+	testCommand(t, before, "func main() {\n\tx := 1\n\nfunc f() {\n\treturn\n}\n", 7, "/}/d", "*")
+	testCommand(t, before, before, 1, "/main", ErrEntry.Error()+"\n")
+	e, _ := testCommand(t, before, before, 1, "/return/", "*")
+	assertCurrent(t, e, 6)
+}
+
+func TestAddressExpr(t *testing.T) {
+	const before = "uno\ndue\ntre\nquattro\ncinque\nsei\n"
+	testCommand(t, before, "uno\ndue\ntre\nquattro\ncinque\n", 1, "$d", "*")
+	testCommand(t, before, "", 1, "%d", "*")
+	testCommand(t, before, "uno\nquattro\ncinque\nsei\n", 2, ".,.+1d", "*")
+	testCommand(t, before, "uno\ndue\ntre\nquattro\n", 1, "#-2,$d", "*")
+	testCommand(t, before, "uno\ndue\ntre\nquattro\ncinque\n", 1, "5+2-1d", "*")
+	testCommand(t, before, "uno\ndue\ntre\nquattro\nsei\n", 3, "+2d", "*")
+	testCommand(t, before, "uno\ndue\nquattro\ncinque\nsei\n", 1, "/tre/-1+1d", "*")
+	testCommand(t, before, before, 3, "-5d", "Line -2 out of range: -5\n")
+	testCommand(t, before, before, 1, "1,#+1l", "Line 8 out of range: #+1\n")
+	testCommand(t, before, before, 1, "1,$+3,1c", "Line 9 out of range: $+3\n")
+	testCommand(t, "", "", 1, "$d", "Line 0 out of range: $\n")
+	testCommand(t, before, before, 1, "1,%d", ErrEntry.Error()+"\n")
+	testCommand(t, before, before, 1, ",%d", ErrEntry.Error()+"\n")
+	testList(t, before, "%l", 1, 6, 1)
+	testList(t, before, "2,20l", 2, 6, 1)
+}
+
+func TestMarks(t *testing.T) {
+	const before = "uno\ndue\ntre\nquattro\ncinque\nsei\n"
+	e, _ := testCommand(t, before, before, 1, "2Ka;5KB;'a,'bl", "      2: due\n      3: tre\n      4: quattro\n      5: cinque\n")
+	exec := func(cmd string) {
+		if _, err := e.Exec(cmd); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	assertMark := func(name byte, line int) {
+		t.Helper()
+		if got, ok := e.marks[name]; line == 0 && ok {
+			t.Errorf("mark %c should be deleted, is %d", name, got)
+		} else if line != 0 && got != line {
+			t.Errorf("mark %c: expected %d got %d", name, line, got)
+		}
+	}
+
+	exec("4,4,1c")
+	assertMark('a', 3)
+	assertMark('b', 6)
+	exec("1d")
+	assertMark('a', 2)
+	exec("'ad")
+	assertMark('a', 0)
+	assertMark('b', 4)
+	exec("u")
+	assertMark('a', 2)
+	assertMark('b', 5)
+	exec("3Kc;3,4,1m")
+	assertLines(t, e, "tre\nquattro\nuno\ndue\ncinque\nsei\n")
+	assertMark('a', 4)
+	assertMark('b', 5)
+	assertMark('c', 1)
+	exec("u")
+	assertMark('a', 2)
+	assertMark('c', 3)
+	exec("y")
+	assertMark('a', 4)
+	assertMark('c', 1)
+	dir, err := ioutil.TempDir("", "edlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e.Path = filepath.Join(dir, "marks.txt")
+	exec("1w")
+	assertMark('a', 3)
+	assertMark('c', 0)
+	if _, err := e.Exec("'cl"); err == nil || err.Error() != "Mark 'c not set" {
+		t.Errorf("wrong error %v", err)
+	}
+}
+
+func TestGlobal(t *testing.T) {
+	const before = "uno\ndue\ntre\nquattro\ncinque\nsei\n"
+	testCommand(t, before, "tre\nsei\n", 1, "1,#G/u/D", "*")
+	testCommand(t, before, "uno\ndue\ntre\nquattro\ncinque\nsei\n", 1, "G/~^[ds]/", "      2:*due\n      6:*sei\n")
+	testCommand(t, before, "uno\ntre\nquattro\ncinque\n", 1, "V/~[nt]/D", "*")
+	testCommand(t, before, "uno\ndue\ntre\nquaTTro\ncinque\nsei\n", 1, "3,#G/t/.Rtt\x1aTT", "      4: quaTTro\n")
+	e, _ := testCommand(t, before, "uno\ndue\ntre\nquattro\ncinque\nsei\nsei\n", 1, "G/sei/.,.,#C", "*")
+	assertCurrent(t, e, 7)
+	e, _ = testCommand(t, before, "tre\nsei\n", 1, "G|o|.,.+1D", "*")
+	if _, err := e.Exec("u"); err != nil {
+		t.Fatal(err)
+	}
+	assertLines(t, e, before)
+	testCommand(t, before, before, 1, "G/u/U", ErrEntry.Error()+"\n")
+	testCommand(t, before, before, 1, "G/u/G/e/D", ErrEntry.Error()+"\n")
+	testCommand(t, before, before, 1, "G/u", ErrEntry.Error()+"\n")
+}
+
+func assertCurrent(t *testing.T, e *Edlin, n int) {
+	if e.Current != n {
+		t.Fatalf("expected current %d got %d\n", n, e.Current)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	e, _ := testCommand(t,
+		"uno\ndue\ntre\nquattro\ncinque\nsei\n",
+		"cinque\nuno\ndue\ntre\nquattro\ncinque\nsei\n", 1, "5,5,1c", "*")
+	assertCurrent(t, e, 1)
+	e, _ = testCommand(t,
+		"uno\ndue\ntre\nquattro\ncinque\nsei\n",
+		"uno\ndue\ntre\nquattro\nuno\ndue\ntre\ncinque\nsei\n", 1, "1,3,5c", "*")
+	assertCurrent(t, e, 5)
+	e, _ = testCommand(t,
+		"uno\ndue\ntre\nquattro\ncinque\nsei\n",
+		"cinque\nuno\ndue\ntre\nquattro\nsei\n", 1, "5,5,1m", "*")
+	assertCurrent(t, e, 1)
+	e, _ = testCommand(t,
+		"uno\ndue\ntre\nquattro\ncinque\nsei\n",
+		"quattro\nuno\ndue\ntre\ncinque\nsei\n", 1, "1,3,5m", "*")
+	assertCurrent(t, e, 2)
+	testCommand(t,
+		"uno\ndue\ntre\nquattro\ncinque\nsei\n",
+		"uno\ncinque\ncinque\ncinque\ndue\ntre\nquattro\ncinque\nsei\n", 1, "5,5,2,3c", "*")
+	testCommand(t,
+		"uno\ndue\ntre\nquattro\ncinque\nsei\n",
+		"uno\ndue\ntre\nquattro\ncinque\nsei\nuno\ndue\n", 1, "1,2,#c", "*")
+	testCommand(t,
+		"uno\ndue\ntre\nquattro\ncinque\nsei\n",
+		"tre\nquattro\ncinque\nsei\nuno\ndue\n", 1, "1,2,#m", "*")
+}
+
+func TestAppendWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vispa.txt")
+	if err := ioutil.WriteFile(path, []byte(vispaTeresa), 0660); err != nil {
+		t.Fatal(err)
+	}
+	fh, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e Edlin
+	var out bytes.Buffer
+	e.Term = NewScriptTerminal("")
+	e.Stdout = &out
+	e.Path = path
+	e.MaxBytes = 40
+	e.Current = 1
+	e.Load(fh)
+
+	if len(e.Lines) != 3 {
+		t.Fatalf("wrong number of lines loaded %d", len(e.Lines))
+	}
+	e.Exec("2a")
+	if len(e.Lines) != 5 || e.Lines[4] != "E tutta giuliva" {
+		t.Fatalf("wrong lines after append: %q", e.Lines)
+	}
+	e.Exec("3w")
+	if len(e.Lines) != 2 || e.Lines[0] != "gentil farfalletta" {
+		t.Fatalf("wrong lines after write: %q", e.Lines)
+	}
+	e.Exec("u")
+	if len(e.Lines) != 5 || e.Lines[0] != "La vispa Teresa" {
+		t.Fatalf("wrong lines after undoing write: %q", e.Lines)
+	}
+	e.Exec("u")
+	if len(e.Lines) != 3 {
+		t.Fatalf("wrong lines after undoing append: %q", e.Lines)
+	}
+	e.Exec("2y")
+	if len(e.Lines) != 2 || e.Lines[0] != "gentil farfalletta" {
+		t.Fatalf("wrong lines after redo: %q", e.Lines)
+	}
+	e.Exec("a")
+	if out.String() != "" {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+	if r, _ := e.Exec("e"); r != Quit {
+		t.Fatalf("wrong return value from E")
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != vispaTeresa {
+		t.Fatalf("file contents changed: %q", string(buf))
+	}
+}
+
+func assertLines(t *testing.T, e *Edlin, after string) {
+	if o := strings.Join(e.Lines, "\n") + "\n"; o != after {
+		t.Fatalf("buffer mismatch: %q", o)
+	}
+}
+
+// tempDir returns a new temporary directory containing files, which maps
+// file names to their contents. The caller removes it.
+func tempDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "edlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0666); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// loadFile loads e.Path into e, giving it an empty ScriptTerminal if it has
+// no terminal, then executes cmds until one of them fails.
+func loadFile(t *testing.T, e *Edlin, cmds ...string) (*Edlin, error) {
+	t.Helper()
+	if e.Term == nil {
+		e.Term = NewScriptTerminal("")
+	}
+	fh, err := os.Open(e.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Load(fh)
+	for _, cmd := range cmds {
+		if _, err := e.Exec(cmd); err != nil {
+			return e, err
+		}
+	}
+	return e, nil
+}
+
+// assertFile checks the contents of path.
+func assertFile(t *testing.T, path, exp string) {
+	t.Helper()
+	if buf, _ := ioutil.ReadFile(path); string(buf) != exp {
+		t.Errorf("wrong contents of %s %q", path, buf)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	const before = "uno\ndue\ntre\nquattro\ncinque\nsei\n"
+	e, _ := testCommand(t, before, "uno\nquattro\ncinque\nsei\n", 1, "2,3d", "*")
+	e.Exec("u")
+	assertLines(t, e, before)
+	assertCurrent(t, e, 1)
+	e.Exec("y")
+	assertLines(t, e, "uno\nquattro\ncinque\nsei\n")
+	assertCurrent(t, e, 2)
+
+	e, _ = testCommand(t, before, "due\ntre\nquattro\ncinque\nsei\n", 1, "1,1,5m;.d", "*")
+	e.Exec("u")
+	assertLines(t, e, before)
+	e.Exec("y")
+	assertLines(t, e, "due\ntre\nquattro\ncinque\nsei\n")
+	e.Exec("2u")
+	assertLines(t, e, before)
+
+	e, _ = testCommand(t, before, "uno\ndue\ntre\nquattro\ncinque\nsei\nuno\ndue\nuno\ndue\n", 1, "1,2,#,2c", "*")
+	e.Exec("u")
+	assertLines(t, e, before)
+	e.Exec("5,6,1c")
+	e.Exec("y")
+	assertLines(t, e, "cinque\nsei\n"+before)
+	testCommand(t, before, before, 1, "u", ErrNothingToUndo.Error()+"\n")
+	testCommand(t, before, before, 1, "y", ErrNothingToRedo.Error()+"\n")
+
+	e, _ = testCommand(t, before, "uno\ndue\nTRE\nquattro\ncinque\nsei\n", 1, "1,#Rtre\x1aTRE", "*")
+	e.Exec("u")
+	assertLines(t, e, before)
+	if e.Dirty {
+		t.Fatalf("buffer dirty after undo")
+	}
+}
+
+func TestRegexp(t *testing.T) {
+	testCommand(t, vispaTeresa, vispaTeresa, 1, "1,#S~^A l[a-z]+ ", "      9: A lei supplicando\n")
+	e, _ := testCommand(t, vispaTeresa, vispaTeresa, 1, "S~^A ", "      3: A volo sorpresa\n")
+	assertCurrent(t, e, 3)
+	e.Exec("s")
+	assertCurrent(t, e, 9)
+
+	const before = "uno due\ntre quattro\ncinque\n"
+	e, _ = testCommand(t, before, "due uno\nquattro tre\ncinque\n", 1, "1,#R~^(\\w+) (?P<second>\\w+)$\x1a${second} $1", "      1:*due uno\n      2: quattro tre\n")
+	e.lastReplace = "[$0]"
+	e.Exec("1,#r")
+	assertLines(t, e, "[due uno]\n[quattro tre]\ncinque\n")
+	testCommand(t, before, "uno due\ntre quattro\ncinque\n", 1, "1,#R~[\x1ax", "Invalid pattern \"[\": error parsing regexp: missing closing ]: `[`\n")
+	testCommand(t, before, before, 1, "1,#R\x1afoo", ErrEntry.Error()+"\n")
+	testCommand(t, before, before, 1, "1,#R~\x1afoo", ErrEntry.Error()+"\n")
+	testCommand(t, before, before, 1, "1,#R", ErrEntry.Error()+"\n")
+	testCommand(t, before, before, 1, "1,#R~", ErrEntry.Error()+"\n")
+
+	// \~ is a literal ~
+	const tilde = "uno\n~/due\n~[tre]\n"
+	testCommand(t, tilde, tilde, 1, "1,#S\\~[", "      3: ~[tre]\n")
+	testCommand(t, tilde, "uno\n$HOME/due\n~[tre]\n", 1, "1,#R\\~/\x1a$HOME/", "      2: $HOME/due\n")
+	testCommand(t, tilde, "uno\n~/due\n", 1, "/\\~[/d", "*")
+}
+
+func testInteractive(t *testing.T, before, after string, cur int, command, keys string, output string) *Edlin {
+	var e *Edlin
+	t.Run(command, func(t *testing.T) {
+		term := NewScriptTerminal(keys)
+		e = &Edlin{Term: term, Current: cur}
+		e.Lines = strings.Split(strings.TrimSuffix(before, "\n"), "\n")
+		e.Exec(command)
+		t.Logf("<%s> -> %q\n", command, term.Output.String())
+		if output != "*" && term.Output.String() != output {
+			t.Errorf("error executing %q, output mismatch", command)
+		}
+		assertLines(t, e, after)
+	})
+	return e
+}
+
+func TestInteractive(t *testing.T) {
+	const before = "uno\ndue tre due\ntre\n"
+	testInteractive(t, before, "uno\nDUE tre due\ntre\n", 1, "1,#?Rdue\x1aDUE", "yn",
+		"      2: due tre due\nO.K.? y\n      2:*DUE tre due\nO.K.? n\n")
+	testInteractive(t, before, "uno\ndue tre DuE\ntre\n", 1, "1,#?R~d(u)e\x1aD${1}E", "ny", "*")
+	e := testInteractive(t, before, "uno\nquattro\ncinque\ndue tre due\ntre\n", 1, "2i", "quattro\rcinquex\x7f\r\x03",
+		"      2:*quattro\n      3:*cinquex\x08 \x08\n      4:*^C\n")
+	assertCurrent(t, e, 4)
+	testInteractive(t, before, "uno!\ndue tre due\ntre\n", 1, "1", "\x1b[13~!\r", "      1:*uno\n      1:*uno!\n")
+	testInteractive(t, before, "uno\ndue t due\ntre\n", 1, "2", "\x1b[12~r\x1b[14~ \x1b[13~\r", "*")
+	testInteractive(t, before, before, 1, "2", "xyz\x03", "*")
+	testInteractive(t, before, "uno\ndue\ntre\n", 1, "2", "\x1b[11~\x1b[11~\x1b[11~\x1b[15~\x1b[13~\r", "      2:*due tre due\n      2:*due@due\n")
+	testInteractive(t, before, before, 1, "?sdue", "nn", "      2: due tre due\nO.K.? n\nNot found\n")
+
+	term := NewScriptTerminal("2,3l\x7fd\r")
+	e = &Edlin{Term: term}
+	if cmd, _ := e.Input(); cmd != "2,3d" {
+		t.Errorf("wrong command %q", cmd)
+	}
+	if out := term.Output.String(); out != "2,3l\x08 \x08d\n" {
+		t.Errorf("wrong output %q", out)
+	}
+}
+
+func TestUTF8(t *testing.T) {
+	const before = "città\n“L'ho presa!”\n"
+	testInteractive(t, before, "città\nè\n“L'ho presa!”\n", 1, "2i", "éè\x7f\x7fè\r\x03",
+		"      2:*éè\x08 \x08\x08 \x08è\n      3:*^C\n")
+	testInteractive(t, before, "citt\n“L'ho presa!”\n", 1, "1", "\x1b[11~\x1b[11~\x1b[11~\x1b[11~\r", "      1:*città\n      1:*citt\n")
+	testInteractive(t, before, "citt!\n“L'ho presa!”\n", 1, "1", "\x1b[12~à!\x1b[13~\r", "      1:*città\n      1:*citt!\n")
+	testInteractive(t, before, "città\n“L'ho\n", 2, "2", "\x1b[12~ \r", "*")
+	testInteractive(t, before, "città\npresa!”\n", 2, "2", "\x1b[14~p\x1b[13~\r", "*")
+	testInteractive(t, before, "città\n«L'ho presa!»\n", 2, "2", "«\x1b[12~”»\r", "      2:*“L'ho presa!”\n      2:*«L'ho presa!»\n")
+	testInteractive(t, before, "città\n日\n“L'ho presa!”\n", 1, "2i", "日本\x7f\r\x03",
+		"      2:*日本\x08 \x08\x08 \x08\n      3:*^C\n")
+	testInteractive(t, before, "città\ne\n“L'ho presa!”\n", 1, "2i", "e\u0301\x7f\r\x03",
+		"      2:*e\u0301\x08e\n      3:*^C\n")
+
+	for _, tc := range []struct {
+		r rune
+		w int
+	}{{'a', 1}, {'à', 1}, {'\u0301', 0}, {'日', 2}, {'ｱ', 1}, {'Ａ', 2}, {'\U0001F600', 2}, {'\u200d', 0}, {'\t', 1}, {0x7f, 1}} {
+		if w := runeWidth(tc.r); w != tc.w {
+			t.Errorf("width of %U: expected %d got %d", tc.r, tc.w, w)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	const before = "uno\ndue tre due\ntre\n"
+	const left, right, home, end, del, ins = "\x1b[D", "\x1b[C", "\x1b[7~", "\x1b[8~", "\x1b[3~", "\x1b[2~"
+	testInteractive(t, before, "uno\nDue tre due\ntre\n", 1, "2", "\x1b[13~"+home+"D\r", "      2:*due tre due\n      2:*due tre due\x08\x08\x08\x08\x08\x08\x08\x08\x08\x08\x08Due tre due\x08\x08\x08\x08\x08\x08\x08\x08\x08\x08ue tre due\n")
+	testInteractive(t, before, "uno\ntre due\ntre\n", 1, "2", "\x1b[13~"+home+del+del+del+del+"\r", "*")
+	testInteractive(t, before, "uno\ndue, tre due\ntre\n", 1, "2", right+right+right+ins+","+"\x1b[13~\r", "*")
+	testInteractive(t, before, "uno\ndue tre\ntre\n", 1, "2", "\x1b[13~"+left+left+left+left+del+del+del+del+end+"\r", "*")
+	testInteractive(t, before, "uno\nuno\ntre\n", 1, "2", del+del+del+"uno\r", "*")
+	testInteractive(t, before, "uno\nd@e tre due\ntre\n", 1, "2", right+right+left+"@\x1b[13~\r", "*")
+	testInteractive(t, before, before, 1, "2", "\x1b[12~ "+home+"X\x1b[15~"+left+"\x03", "      2:*due tre due\n      2:*due\x08\x08\x08Xue\x08\x08ue@^C\n")
+	testInteractive(t, "a\tb\n", "\tb\n", 1, "1", "\x1b[13~"+home+del+"\r", "      1:*a\tb\n      1:*a^Ib\x08\x08\x08\x08^Ib \x08\x08\x08\x08^Ib\n")
+	testInteractive(t, "a\tb\n", "ab\n", 1, "1", "\x1b[13~"+left+left+del+"\r", "*")
+
+	input := func(keys, cmd, output string) {
+		t.Helper()
+		term := NewScriptTerminal(keys)
+		e := &Edlin{Term: term}
+		if got, _ := e.Input(); got != cmd {
+			t.Errorf("wrong command %q", got)
+		}
+		if got := term.Output.String(); got != output {
+			t.Errorf("wrong output %q", got)
+		}
+	}
+	input("abc"+left+left+"X\r", "aXbc", "abc\x08\x08Xbc\x08\x08bc\n")
+	input("abc"+home+del+"\r", "bc", "abc\x08\x08\x08bc \x08\x08\x08bc\n")
+	input("abc"+left+ins+"X\r", "abX", "abc\x08X\n")
+	input("日本"+left+"x\r", "日x本", "日本\x08\x08x本\x08\x08本\n")
+	input("ab"+left+"\x7f\r", "b", "ab\x08\x08b \x08\x08b\n")
+	input("é"+left+"e\u0301"+right+"\r", "e\u0301é", "é\x08eé\x08\x08e\u0301é\x08é\n")
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const up, down, f3 = "\x1b[A", "\x1b[B", "\x1b[13~"
+	term := NewScriptTerminal("1,2l\r\r1,#Rdue\x1atre\r1,#Rd" + f3 + "\r" + up + up + down + "\r" + up + up + up + up + down + down + down + "3\r")
+	e := &Edlin{Term: term, HistoryFile: filepath.Join(dir, "history")}
+	for _, tgt := range []string{"1,2l", "", "1,#Rdue\x1atre", "1,#Rdue\x1atre", "1,#Rdue\x1atre", "3"} {
+		if cmd, _ := e.Input(); cmd != tgt {
+			t.Errorf("wrong command %q expected %q", cmd, tgt)
+		}
+	}
+	if out := term.Output.String(); !strings.Contains(out, "1,2l        \x08") {
+		t.Errorf("wrong output %q", out)
+	}
+
+	// the history is loaded by the next session
+	term = NewScriptTerminal(up + up + "\r")
+	e = &Edlin{Term: term, HistoryFile: filepath.Join(dir, "history")}
+	if cmd, _ := e.Input(); cmd != "1,#Rdue\x1atre" {
+		t.Errorf("wrong command %q", cmd)
+	}
+	if !reflect.DeepEqual(e.history, []string{"1,2l", "1,#Rdue\x1atre", "3", "1,#Rdue\x1atre"}) {
+		t.Errorf("wrong history %q", e.history)
+	}
+}
+
+// interruptTerminal calls Interrupt every time a key is read.
+type interruptTerminal struct {
+	*ScriptTerminal
+	e *Edlin
+}
+
+func (t *interruptTerminal) ReadKey() ([]byte, error) {
+	t.e.Interrupt()
+	return t.ScriptTerminal.ReadKey()
+}
+
+func TestInterrupt(t *testing.T) {
+	term := NewScriptTerminal("1,2l\x03")
+	e := &Edlin{Term: term}
+	if cmd, err := e.Input(); cmd != "" || err != ErrInterrupted {
+		t.Errorf("wrong result %q %v", cmd, err)
+	}
+	if out := term.Output.String(); out != "1,2l^C\n" {
+		t.Errorf("wrong output %q", out)
+	}
+
+	e = &Edlin{Current: 1, Lines: []string{"uno", "due", "tre", "due"}}
+	e.Term = &interruptTerminal{NewScriptTerminal("yy"), e}
+	if _, err := e.Exec("1,#?Rdue\x1aDUE"); err != ErrInterrupted {
+		t.Errorf("wrong error %v", err)
+	}
+	assertLines(t, e, "uno\nDUE\ntre\ndue\n")
+	if _, err := e.Exec("1,#Rdue\x1aDUE"); err != nil {
+		t.Errorf("interrupt not cleared: %v", err)
+	}
+
+	// between the matches on the same line
+	e = &Edlin{Current: 1, Lines: []string{"due due", "due"}}
+	e.Term = &interruptTerminal{NewScriptTerminal("yy"), e}
+	if _, err := e.Exec("1,#?Rdue\x1aDUE"); err != ErrInterrupted {
+		t.Errorf("wrong error %v", err)
+	}
+	assertLines(t, e, "DUE due\ndue\n")
+	e.Term = &interruptTerminal{NewScriptTerminal("\x03"), e}
+	if _, err := e.Exec("q"); err != ErrInterrupted {
+		t.Errorf("wrong error %v", err)
+	}
+}
+
+func TestEmergencySave(t *testing.T) {
+	dir := tempDir(t, map[string]string{"vispa.txt": vispaTeresa})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vispa.txt")
+
+	e := &Edlin{Term: NewScriptTerminal(""), Stdout: ioutil.Discard, Path: path}
+	if p, err := e.EmergencySave(); p != "" || err != nil {
+		t.Errorf("unexpected emergency save %q %v", p, err)
+	}
+
+	e = &Edlin{Stdout: ioutil.Discard, Path: path, MaxBytes: 40, Current: 1}
+	if err := e.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	loadFile(t, e, "1w;1d")
+	p, err := e.EmergencySave()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != filepath.Join(dir, ".vispa.txt.edlin-recover") {
+		t.Errorf("wrong path %q", p)
+	}
+	_, rc, err := e.openRecover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if exp := strings.Replace(vispaTeresa, "avea tra l'erbetta\n", "", 1); string(buf) != exp {
+		t.Errorf("wrong recovery file %q", buf)
+	}
+
+	// the session stays blocked until the process exits
+	done := make(chan struct{})
+	go func() {
+		e.Exec("1d")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Errorf("command executed after the emergency save")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// but the lock can still be released before exiting
+	unlocked := make(chan struct{})
+	go func() {
+		e.Unlock()
+		close(unlocked)
+	}()
+	select {
+	case <-unlocked:
+	case <-time.After(time.Second):
+		t.Fatalf("Unlock blocked after the emergency save")
+	}
+	if _, err := os.Stat(e.LockPath()); !os.IsNotExist(err) {
+		t.Errorf("lock file not removed: %v", err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	dir := tempDir(t, map[string]string{"vispa.txt": vispaTeresa})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vispa.txt")
+
+	// first session, killed after some changes
+	e, _ := loadFile(t, &Edlin{Stdout: ioutil.Discard, Path: path, MaxBytes: 40, Current: 1, Recover: true}, "1w", "1d")
+	if _, err := os.Stat(e.RecoverPath()); err != nil {
+		t.Fatalf("recovery file not written: %v", err)
+	}
+	e.Exec("1u")
+	e.Exec("1u")
+	if _, err := os.Stat(e.RecoverPath()); err == nil {
+		t.Errorf("recovery file not removed after undoing all changes")
+	}
+	e.Exec("1d")
+	e.closeInput()
+
+	// second session, shows the differences then restores
+	term := NewScriptTerminal("sr")
+	e = &Edlin{Term: term, Path: path, MaxBytes: 40, Current: 1, Recover: true}
+	restored, err := e.CheckRecover()
+	if err != nil || !restored {
+		t.Fatalf("not restored: %v", err)
+	}
+	if !strings.Contains(term.Output.String(), "Files differ, too large to compare\n") {
+		t.Errorf("wrong diff of files larger than MaxBytes %q", term.Output.String())
+	}
+	if !e.Dirty {
+		t.Errorf("restored buffer not dirty")
+	}
+	if _, err := e.Exec("e"); err != nil {
+		t.Fatal(err)
+	}
+	buf, _ := ioutil.ReadFile(path)
+	if exp := strings.Replace(vispaTeresa, "La vispa Teresa\n", "", 1); string(buf) != exp {
+		t.Errorf("wrong file after restore %q", buf)
+	}
+	assertDir(t, dir, "vispa.txt")
+
+	// discard
+	e, _ = loadFile(t, &Edlin{Stdout: ioutil.Discard, Path: path, Current: 1, Recover: true}, "1d")
+	e.closeInput()
+	term = NewScriptTerminal("sd")
+	e = &Edlin{Term: term, Path: path, Current: 1}
+	if restored, err := e.CheckRecover(); restored || err != nil {
+		t.Errorf("unexpected result %v %v", restored, err)
+	}
+	if !strings.Contains(term.Output.String(), "@@ -1,4 +1,3 @@\n-avea tra l'erbetta\n") {
+		t.Errorf("wrong diff %q", term.Output.String())
+	}
+	if _, err := os.Stat(e.RecoverPath()); err == nil {
+		t.Errorf("recovery file not discarded")
+	}
+
+	// batch mode
+	e, _ = loadFile(t, &Edlin{Stdout: ioutil.Discard, Path: path, Current: 1, Recover: true}, "1d")
+	e.closeInput()
+	e = &Edlin{Term: NewScriptTerminal(""), Stdout: ioutil.Discard, Path: path, Current: 1, Batch: true}
+	if _, err := e.CheckRecover(); !errors.Is(err, ErrRecoverExists) {
+		t.Errorf("wrong error in batch mode %v", err)
+	}
+}
+
+func TestRecoverThrottle(t *testing.T) {
+	dir := tempDir(t, map[string]string{"file.txt": "uno\n"})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.txt")
+
+	e, _ := loadFile(t, &Edlin{Stdout: ioutil.Discard, Path: path, Current: 1, Recover: true}, "1,1,2c")
+	readRecover := func() string {
+		t.Helper()
+		buf, err := ioutil.ReadFile(e.RecoverPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf)
+	}
+
+	// the first change is saved right away, the next ones are batched
+	first := readRecover()
+	if !strings.HasSuffix(first, "\nuno\nuno\n") {
+		t.Fatalf("wrong recovery file %q", first)
+	}
+	for i := 1; i < recoverChanges; i++ {
+		e.Exec("1,1,2c")
+		if got := readRecover(); got != first {
+			t.Fatalf("recovery file updated after %d changes", i)
+		}
+	}
+	e.Exec("1d")
+	if got := readRecover(); got == first {
+		t.Errorf("recovery file not updated after %d changes", recoverChanges)
+	}
+
+	// or after recoverInterval
+	saved := readRecover()
+	e.Exec("1d")
+	if got := readRecover(); got != saved {
+		t.Errorf("recovery file updated too early")
+	}
+	e.recoverTime = time.Now().Add(-recoverInterval)
+	e.Exec("1d")
+	if got := readRecover(); got == saved {
+		t.Errorf("recovery file not updated after %v", recoverInterval)
+	}
+}
+
+// assertDir checks that dir contains only the files in names.
+func assertDir(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for _, fi := range fis {
+		found = append(found, fi.Name())
+	}
+	if !reflect.DeepEqual(found, names) {
+		t.Errorf("wrong files in %s: %q", dir, found)
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir := tempDir(t, map[string]string{"file.txt": "uno\ndue\ntre\n"})
+	defer os.RemoveAll(dir)
+
+	edit := func(path, cmds string) {
+		t.Helper()
+		e := &Edlin{Term: NewScriptTerminal("y"), Stdout: ioutil.Discard, Path: path, Current: 1}
+		if _, err := loadFile(t, e, strings.Split(cmds, "\n")...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// mode is kept
+	path := filepath.Join(dir, "file.txt")
+	os.Chmod(path, 0751)
+	edit(path, "1d\n1w\ne")
+	assertFile(t, path, "due\ntre\n")
+	if fi, _ := os.Stat(path); fi.Mode() != 0751 {
+		t.Errorf("wrong mode %v", fi.Mode())
+	}
+	assertDir(t, dir, "file.txt")
+
+	// symlinks are followed
+	link := filepath.Join(dir, "link.txt")
+	os.Symlink("file.txt", link)
+	edit(link, "1d\ne")
+	assertFile(t, path, "tre\n")
+	if fi, _ := os.Lstat(link); fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("symlink replaced")
+	}
+	os.Remove(link)
+
+	// hard links are kept
+	hard := filepath.Join(dir, "hard.txt")
+	os.Link(path, hard)
+	edit(path, "1rtre\x1aTRE\ne")
+	assertFile(t, hard, "TRE\n")
+	os.Remove(hard)
+	assertDir(t, dir, "file.txt")
+
+	// extended attributes are kept, if the file system supports them
+	if err := unix.Setxattr(path, "user.edlin", []byte("vispa"), 0); err == nil {
+		edit(path, "1rE\x1ae\ne")
+		buf := make([]byte, 16)
+		if n, err := unix.Getxattr(path, "user.edlin", buf); err != nil || string(buf[:n]) != "vispa" {
+			t.Errorf("extended attribute lost %v", err)
+		}
+	}
+
+	// the temporary file is removed by undo
+	edit(path, "1w\nu\nq")
+	assertDir(t, dir, "file.txt")
+}
+
+func TestDiff(t *testing.T) {
+	var out bytes.Buffer
+	a := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	b := []string{"a", "B", "c", "d", "e", "f", "g", "h", "i", "j", "k"}
+	if !writeDiff(&out, "x", "y", a, b) {
+		t.Fatal("no differences")
+	}
+	const exp = "--- x\n+++ y\n@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n@@ -8,3 +8,4 @@\n h\n i\n j\n+k\n"
+	if out.String() != exp {
+		t.Errorf("wrong diff %q", out.String())
+	}
+	if writeDiff(&out, "x", "y", a, a) {
+		t.Errorf("differences found between equal files")
+	}
+
+	// too many differences
+	a, b = make([]string, 3000), make([]string, 3000)
+	for i := range a {
+		a[i], b[i] = "a"+strconv.Itoa(i), "b"+strconv.Itoa(i)
+	}
+	out.Reset()
+	if !writeDiff(&out, "x", "y", a, b) || out.String() != "--- x\n+++ y\nFiles differ, more than 1000 lines changed\n" {
+		t.Errorf("wrong diff %q", out.String())
+	}
+	if _, ok := diffLines(a[:10], b[:10], 19); ok {
+		t.Errorf("diff found with too few edits")
+	}
+	if ops, ok := diffLines(a[:10], b[:10], 20); !ok || len(ops) != 20 {
+		t.Errorf("wrong diff with just enough edits %v %v", ops, ok)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	const before = "uno\ndue\ntre\n"
+	term := NewScriptTerminal("2i\nquattro\ncinque\n.\n1,#?Rtre\x1aTRE\ny\n3\nsei\r\nSsett\xe9\n1,2l\n")
+	e := &Edlin{Term: term, Batch: true, Current: 1}
+	e.Lines = strings.Split(strings.TrimSuffix(before, "\n"), "\n")
+	errs := 0
+	for {
+		cmdstr, err := e.Input()
+		if err != nil {
+			break
+		}
+		if _, err := e.Exec(cmdstr); err != nil {
+			errs++
+		}
+	}
+	t.Logf("%q", term.Output.String())
+	assertLines(t, e, "uno\nquattro\nsei\ndue\nTRE\n")
+	if out := term.Output.String(); out != "      5: tre\nNot found\n      1: uno\n      2: quattro\n" {
+		t.Errorf("wrong output %q", out)
+	}
+	if errs != 1 {
+		t.Errorf("wrong error count %d", errs)
+	}
+
+	// I without a line number inserts before the current line
+	for _, tc := range []struct{ before, after string }{
+		{"", "new\n"},
+		{"uno\ndue\n", "uno\nnew\ndue\n"},
+	} {
+		e := &Edlin{Term: NewScriptTerminal("new\n.\n"), Batch: true, Current: 2}
+		if tc.before == "" {
+			e.Current = 0
+		} else {
+			e.Lines = strings.Split(strings.TrimSuffix(tc.before, "\n"), "\n")
+		}
+		if _, err := e.Exec("i"); err != nil {
+			t.Errorf("i: %v", err)
+		}
+		assertLines(t, e, tc.after)
+	}
+
+	// an invalid UTF-8 sequence ends at the first byte that doesn't continue it
+	kr := newKeyReader(strings.NewReader("\xe9\n\xe2\x82a"))
+	for _, exp := range []string{"\xe9", "\n", "\xe2\x82", "a"} {
+		if key, err := kr.Next(); err != nil || string(key) != exp {
+			t.Errorf("wrong key %q %v, expected %q", key, err, exp)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	const before = "uno\ndue\ntre\n"
+	e, _ := testCommand(t, before, before, 1, "2,1l", "*")
+	if _, err := e.Exec("2,1l"); err != ErrEntry {
+		t.Errorf("wrong error %v", err)
+	}
+	if _, err := e.Exec("1,#Squattro"); err != ErrNotFound {
+		t.Errorf("wrong error %v", err)
+	}
+	var perr *PatternError
+	if _, err := e.Exec("1,#S~("); !errors.As(err, &perr) || perr.Pattern != "(" {
+		t.Errorf("wrong error %v", err)
+	}
+
+	// a failed save must leave the session and the buffer alone
+	e.Path = filepath.Join(os.DevNull, "file")
+	r, err := e.Exec("e")
+	var ioerr *IOError
+	if r != Continue || !errors.As(err, &ioerr) || ioerr.Op != "write" || filepath.Dir(ioerr.Path) != os.DevNull {
+		t.Errorf("wrong result %v %v", r, err)
+	}
+	assertLines(t, e, before)
+	if _, err := e.Exec("u"); err != ErrNothingToUndo {
+		t.Errorf("wrong error %v", err)
+	}
+}
+
+func TestModified(t *testing.T) {
+	dir := tempDir(t, map[string]string{"vispa.txt": vispaTeresa})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vispa.txt")
+
+	// F saves without leaving the buffer, the part of the file that wasn't
+	// loaded is read from the saved file
+	e, err := loadFile(t, &Edlin{Stdout: ioutil.Discard, Path: path, MaxBytes: 40, Current: 1}, "1w", "1d", "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := strings.Replace(vispaTeresa, "avea tra l'erbetta\n", "", 1)
+	assertFile(t, path, saved)
+	if e.Dirty {
+		t.Errorf("buffer dirty after save")
+	}
+	if _, err := e.Exec("1a;1,#d"); err != nil || len(e.Lines) != 0 {
+		t.Fatalf("wrong lines %q %v", e.Lines, err)
+	}
+
+	// changes made by someone else are detected
+	ioutil.WriteFile(path+".new", []byte("uno\n"), 0666)
+	os.Rename(path+".new", path)
+	term := NewScriptTerminal("sa")
+	e.Term, e.Stdout = term, term
+	if _, err := e.Exec("e"); err != ErrChanged {
+		t.Errorf("wrong error %v", err)
+	}
+	if !strings.Contains(term.Output.String(), "Files differ, too large to compare\n") {
+		t.Errorf("wrong diff %q", term.Output.String())
+	}
+	assertFile(t, path, "uno\n")
+	e.Term, e.Stdout = NewScriptTerminal("o"), ioutil.Discard
+	if _, err := e.Exec("w"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Exec("e"); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, path, strings.Replace(saved, "A volo sorpresa\ngentil farfalletta\n", "", 1))
+	assertDir(t, dir, "vispa.txt")
+
+	// touching the file doesn't count as a change
+	e, _ = loadFile(t, &Edlin{Stdout: ioutil.Discard, Path: path, Current: 1}, "1d")
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(path, later, later)
+	if _, err := e.Exec("e"); err != nil {
+		t.Errorf("touched file reported as changed: %v", err)
+	}
+
+	ioutil.WriteFile(path, []byte("uno\ndue\n"), 0666)
+	term = NewScriptTerminal("sa")
+	e, _ = loadFile(t, &Edlin{Term: term, Path: path, Current: 1}, "1d")
+	ioutil.WriteFile(path, []byte("uno\ndue\ntre\n"), 0666)
+	if _, err := e.Exec("w"); err != ErrChanged {
+		t.Errorf("wrong error %v", err)
+	}
+	if !strings.Contains(term.Output.String(), "@@ -1,3 +1,1 @@\n-uno\n due\n-tre\n") {
+		t.Errorf("wrong diff %q", term.Output.String())
+	}
+}
+
+func TestLock(t *testing.T) {
+	dir := tempDir(t, map[string]string{"file.txt": "uno\n"})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.txt")
+
+	e1 := &Edlin{Stdout: ioutil.Discard, Path: path}
+	if err := e1.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	e2 := &Edlin{Stdout: ioutil.Discard, Path: path, Current: 1}
+	err := e2.Lock()
+	var lerr *LockError
+	if !errors.As(err, &lerr) || lerr.PID != os.Getpid() || lerr.User == "" || !errors.Is(err, ErrLocked) {
+		t.Fatalf("wrong error %v", err)
+	}
+	e2.ReadOnly = true
+	loadFile(t, e2, "1d")
+	for _, cmd := range []string{"e", "f", "w"} {
+		if _, err := e2.Exec(cmd); err != ErrReadOnly {
+			t.Errorf("%s: wrong error %v", cmd, err)
+		}
+	}
+	assertFile(t, path, "uno\n")
+
+	e1.Unlock()
+	assertDir(t, dir, "file.txt")
+	if err := e2.Lock(); err != nil {
+		t.Errorf("lock not released: %v", err)
+	}
+	e2.Unlock()
+}
+
+func TestEOL(t *testing.T) {
+	dir := tempDir(t, map[string]string{
+		"dos.txt":   "uno\r\ndue\r\ntre\r\n",
+		"mac.txt":   "uno\rdue\rtre\r",
+		"mixed.txt": "uno\r\ndue\r\ntre\nquattro\r\n",
+	})
+	defer os.RemoveAll(dir)
+
+	edit := func(name string, cmds ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		e := &Edlin{Stdout: &out, Path: filepath.Join(dir, name), MaxBytes: 5, Current: 1}
+		if _, err := loadFile(t, e, cmds...); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	edit("dos.txt", "1rn\x1aN", "e")
+	assertFile(t, filepath.Join(dir, "dos.txt"), "uNo\r\ndue\r\ntre\r\n")
+	edit("mac.txt", "1d", "e")
+	assertFile(t, filepath.Join(dir, "mac.txt"), "due\rtre\r")
+
+	// mixed line endings are reported when they are read
+	if out := edit("mixed.txt", "1a", "oeol=lf", "f", "o", "e"); out != "Mixed line endings (1 LF, 2 CRLF), saving with CRLF\neol=LF\nencoding=UTF-8\n" {
+		t.Errorf("wrong output %q", out)
+	}
+	assertFile(t, filepath.Join(dir, "mixed.txt"), "uno\ndue\ntre\nquattro\n")
+	edit("mixed.txt", "oeol=CRLF", "1d", "e")
+	assertFile(t, filepath.Join(dir, "mixed.txt"), "due\r\ntre\r\nquattro\r\n")
+
+	// changing the format is undone like the other changes
+	e := &Edlin{Stdout: ioutil.Discard, Lines: []string{"uno", "due"}, Current: 1}
+	for _, cmd := range []string{"1d", "oeol=crlf", "oencoding=cp437", "u"} {
+		e.Exec(cmd)
+	}
+	if e.EOL != CRLF || e.Encoding != nil || !e.Dirty {
+		t.Errorf("wrong state after undo %v %v %v", e.EOL, e.Encoding, e.Dirty)
+	}
+	e.Exec("2u")
+	if e.EOL != LF || e.Dirty {
+		t.Errorf("wrong state after undo %v %v", e.EOL, e.Dirty)
+	}
+	e.Exec("3y")
+	if e.EOL != CRLF || e.Encoding != CP437 || !e.Dirty {
+		t.Errorf("wrong state after redo %v %v %v", e.EOL, e.Encoding, e.Dirty)
+	}
+
+	testCommand(t, "uno\n", "uno\n", 1, "oeol=dos", ErrEntry.Error()+"\n")
+	testCommand(t, "uno\n", "uno\n", 1, "ocharset=utf8", ErrEntry.Error()+"\n")
+
+	// the option ends at the command separator
+	e, _ = testCommand(t, "uno\n", "uno\n", 1, "oeol=crlf;1l", "      1:*uno\n")
+	if e.EOL != CRLF {
+		t.Errorf("option not set before ;: %v", e.EOL)
+	}
+	testCommand(t, "uno\n", "uno\n", 1, "o;1l", "eol=LF\nencoding=UTF-8\n      1:*uno\n")
+}
+
+func TestEncoding(t *testing.T) {
+	utf16le := func(s string) string {
+		var b []byte
+		for _, u := range utf16.Encode([]rune(s)) {
+			b = append(b, byte(u), byte(u>>8))
+		}
+		return string(b)
+	}
+	dir := tempDir(t, map[string]string{
+		"dos.txt":   "caf\x82\r\n\xc9\xcd\xbb\r\n",
+		"utf16.txt": "\xff\xfe" + utf16le("uno\r\ndue\r\ntre\r\nquattro\r\n"),
+		"bom.txt":   "\xef\xbb\xbfuno\n",
+		"città.txt": "città\n日本\nà\n日\n",
+	})
+	defer os.RemoveAll(dir)
+
+	edit := func(name string, enc *Encoding, cmds ...string) (*Edlin, error) {
+		t.Helper()
+		e := &Edlin{Stdout: ioutil.Discard, Path: filepath.Join(dir, name), Encoding: enc, MaxBytes: 8, Current: 1}
+		return loadFile(t, e, cmds...)
+	}
+
+	e, err := edit("dos.txt", CP437, "1ré\x1aé", "e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLines(t, e, "café\n╔═╗\n")
+	assertFile(t, filepath.Join(dir, "dos.txt"), "caf\x82\r\n\xc9\xcd\xbb\r\n")
+
+	// the part of the file read after F comes from the saved file
+	e, err = edit("utf16.txt", nil, "1rn\x1aN", "f", "1,#d", "a", "e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(dir, "utf16.txt"), "\xff\xfe"+utf16le("tre\r\nquattro\r\n"))
+	if e.Encoding != UTF16LE {
+		t.Errorf("wrong encoding %v", e.Encoding)
+	}
+
+	edit("bom.txt", nil, "1rn\x1aN", "e")
+	assertFile(t, filepath.Join(dir, "bom.txt"), "\xef\xbb\xbfuNo\n")
+
+	// characters that can't be encoded are reported
+	_, err = edit("città.txt", nil, "oencoding=latin1", "e")
+	if err == nil || err.Error() != "Characters not representable in ISO-8859-1 on lines 2, 4" {
+		t.Errorf("wrong error %v", err)
+	}
+	edit("città.txt", nil, "oencoding=latin-1", "2d", "2a", "3d", "e")
+	assertFile(t, filepath.Join(dir, "città.txt"), "citt\xe0\n\xe0\n")
+
+	// recovery keeps the encoding
+	e, _ = edit("utf16.txt", nil, "1d")
+	if _, err := e.EmergencySave(); err != nil {
+		t.Fatal(err)
+	}
+	e.closeInput()
+	e = &Edlin{Term: NewScriptTerminal("r"), Path: filepath.Join(dir, "utf16.txt"), Current: 1}
+	if restored, err := e.CheckRecover(); err != nil || !restored {
+		t.Fatalf("not restored %v", err)
+	}
+	if _, err := e.Exec("e"); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(dir, "utf16.txt"), "\xff\xfe"+utf16le("quattro\r\n"))
+}
+
+func TestLongLines(t *testing.T) {
+	long := strings.Repeat("x", 50000) + "mid" + strings.Repeat("x", 50000)
+	dir := tempDir(t, map[string]string{"long.txt": "uno\n" + long + "\ntre\n"})
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	e := &Edlin{Stdout: &out, Path: filepath.Join(dir, "long.txt"), MaxBytes: 8, Current: 1}
+	if _, err := loadFile(t, e, "a", "2l"); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "      2: " + long[:1797] + "... (98206 more characters)\n"; out.String() != exp {
+		t.Errorf("wrong output %q", out.String())
+	}
+
+	// displayed whole when the size of the screen is unknown
+	out.Reset()
+	term := NewScriptTerminal("")
+	term.Cols, term.Rows = 0, 0
+	e.Term = term
+	if _, err := e.Exec("2l"); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "      2: " + long + "\n"; out.String() != exp {
+		t.Errorf("line cut with unknown screen size: %d bytes", out.Len())
+	}
+	e.Term = NewScriptTerminal("")
+	if _, err := e.Exec("2rmid\x1aMID"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Exec("e"); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(dir, "long.txt"), "uno\n"+strings.Replace(long, "mid", "MID", 1)+"\ntre\n")
+
+	testInteractive(t, long, long+"!\n", 1, "1", "\x1b[13~!\r", "*")
+}
+
+func TestFileEnd(t *testing.T) {
+	dir := tempDir(t, map[string]string{
+		"noeol.txt": "uno\ndue\ntre",
+		"paged.txt": "uno\ndue\ntre",
+		"saved.txt": "uno\ndue\ntre",
+		"dos.txt":   "uno\r\ndue\r\n\x1a",
+		"junk.txt":  "uno\r\ndue\x1ajunk\r\n",
+		"bin.txt":   "uno\r\n\x1a\r\n",
+		"rec.txt":   "uno\r\ndue\r\ntre\x1a",
+	})
+	defer os.RemoveAll(dir)
+
+	edit := func(e *Edlin, name string, cmds ...string) (*Edlin, string) {
+		t.Helper()
+		var out bytes.Buffer
+		e.Stdout, e.Path, e.Current = &out, filepath.Join(dir, name), 1
+		if _, err := loadFile(t, e, cmds...); err != nil {
+			t.Fatal(err)
+		}
+		return e, out.String()
+	}
+
+	edit(&Edlin{}, "noeol.txt", "1d", "e")
+	assertFile(t, filepath.Join(dir, "noeol.txt"), "due\ntre")
+	edit(&Edlin{MaxBytes: 5}, "paged.txt", "1d", "e")
+	assertFile(t, filepath.Join(dir, "paged.txt"), "due\ntre")
+
+	// the rest of the file is read from the saved file
+	e, _ := edit(&Edlin{MaxBytes: 5}, "saved.txt", "1rn\x1aN", "f", "1a", "1a")
+	assertLines(t, e, "uNo\ndue\ntre\n")
+	assertFile(t, filepath.Join(dir, "saved.txt"), "uNo\ndue\ntre")
+	if !e.NoFinalEOL {
+		t.Errorf("missing final line ending not detected")
+	}
+
+	edit(&Edlin{}, "dos.txt", "1d", "e")
+	assertFile(t, filepath.Join(dir, "dos.txt"), "due\r\n\x1a")
+	e, out := edit(&Edlin{}, "junk.txt", "e")
+	assertLines(t, e, "uno\ndue\n")
+	assertFile(t, filepath.Join(dir, "junk.txt"), "uno\r\ndue\x1a")
+	if out != "Text after the end of file marker (^Z) ignored\n"+EndOfInputFileMsg {
+		t.Errorf("wrong output %q", out)
+	}
+	e, _ = edit(&Edlin{Binary: true}, "bin.txt", "e")
+	assertLines(t, e, "uno\n\x1a\n")
+	assertFile(t, filepath.Join(dir, "bin.txt"), "uno\r\n\x1a\r\n")
+
+	// recovery file
+	e, _ = edit(&Edlin{MaxBytes: 5, Recover: true}, "rec.txt", "1d")
+	e.closeInput()
+	e = &Edlin{Term: NewScriptTerminal("r"), Path: e.Path, MaxBytes: 5, Current: 1}
+	if restored, err := e.CheckRecover(); err != nil || !restored {
+		t.Fatalf("not restored: %v", err)
+	}
+	if _, err := e.Exec("e"); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, filepath.Join(dir, "rec.txt"), "due\r\ntre\x1a")
+}
+
+func TestSaveAs(t *testing.T) {
+	dir := tempDir(t, map[string]string{"a.txt": "uno\ndue\ntre\n"})
+	defer os.RemoveAll(dir)
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	e, err := loadFile(t, &Edlin{Stdout: ioutil.Discard, Path: path("a.txt"), Current: 1},
+		"2,3x "+path("b.txt"), "1x+"+path("b.txt"), "x "+path("c.txt"), "oencoding=utf-16le", "1x+"+path("d.txt"), "2x+"+path("d.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, path("b.txt"), "due\ntre\nuno\n")
+	assertFile(t, path("c.txt"), "uno\ndue\ntre\n")
+	assertFile(t, path("d.txt"), "\xff\xfeu\x00n\x00o\x00\n\x00d\x00u\x00e\x00\n\x00")
+	testCommand(t, "uno\n", "uno\n", 1, "2,3x "+path("b.txt"), ErrEntry.Error()+"\n")
+	testCommand(t, "uno\n", "uno\n", 1, "x", ErrEntry.Error()+"\n")
+	testCommand(t, "", "", 1, "x "+path("empty.txt"), "")
+	assertFile(t, path("empty.txt"), "")
+
+	// the file being edited is only written by saving it
+	e, err = loadFile(t, &Edlin{Stdout: ioutil.Discard, Path: path("a.txt"), MaxBytes: 5, Current: 1}, "1x "+path("a.txt"))
+	if err != ErrSameFile {
+		t.Errorf("wrong error %v", err)
+	}
+	if _, err := e.Exec("e"); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, path("a.txt"), "uno\ndue\ntre\n")
+
+	// save as, the lock follows the file
+	e = &Edlin{Stdout: ioutil.Discard, Path: path("a.txt"), Current: 1}
+	if err := e.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadFile(t, e, "1d", "f "+path("e.txt"), "1d"); err != nil {
+		t.Fatal(err)
+	}
+	if e.Path != path("e.txt") {
+		t.Errorf("wrong path %q", e.Path)
+	}
+	assertFile(t, path("a.txt"), "uno\ndue\ntre\n")
+	assertFile(t, path("e.txt"), "due\ntre\n")
+	assertDir(t, dir, ".e.txt.edlin-lock", "a.txt", "b.txt", "c.txt", "d.txt", "e.txt", "empty.txt")
+	if _, err := e.Exec("e"); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, path("e.txt"), "tre\n")
+	e.Unlock()
+
+	// an existing file is only overwritten if the user says so
+	e, _ = loadFile(t, &Edlin{Term: NewScriptTerminal("n\ny\n"), Stdout: ioutil.Discard, Path: path("a.txt"), Current: 1, Batch: true}, "1d", "f "+path("b.txt"))
+	assertFile(t, path("b.txt"), "due\ntre\nuno\n")
+	if _, err := e.Exec("f " + path("b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, path("b.txt"), "due\ntre\n")
+}
+
+func TestTransfer(t *testing.T) {
+	dir := tempDir(t, map[string]string{
+		"a.txt":   "a1\na2\na3\na4\n",
+		"b.txt":   "b1\nb2\n",
+		"dos.txt": "d1\r\nd2\r\n\x1a",
+	})
+	defer os.RemoveAll(dir)
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	testCommand(t, "uno\ndue\n", "uno\na1\na2\na3\na4\ndue\n", 1, "2t"+path("a.txt"), "")
+	testCommand(t, "uno\ndue\n", "uno\ndue\na2\na3\n", 1, "3t "+path("a.txt")+":2,3", "")
+	testCommand(t, "uno\ndue\n", "a3\nuno\ndue\n", 1, "t"+path("a.txt")+":3", "")
+	testCommand(t, "uno\ndue\n", "a3\na4\nuno\ndue\n", 1, "t"+path("a.txt")+":3,", "")
+	testCommand(t, "uno\ndue\n", "a1\nb1\nuno\ndue\n", 1, "t"+path("?.txt")+":,1", "")
+	testCommand(t, "uno\n", "d1\nd2\nuno\n", 1, "t"+path("dos.txt"), "")
+	testCommand(t, "uno\n", "uno\n", 1, "t"+path("a.txt")+":3,2", ErrEntry.Error()+"\n")
+	testCommand(t, "uno\n", "uno\n", 1, "3t"+path("a.txt"), ErrEntry.Error()+"\n")
+
+	// nothing read
+	e, _ := testCommand(t, "uno\ndue\n", "uno\ndue\n", 1, "2t"+path("a.txt")+":10,20", "")
+	assertCurrent(t, e, 1)
+	e, _ = testCommand(t, "uno\ndue\n", "uno\ndue\n", 1, "2t"+path("missing.txt"), "*")
+	assertCurrent(t, e, 1)
+	if _, err := e.Exec("2t" + path("*.md")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("wrong error %v", err)
+	}
+
+	testCommand(t, "uno\n", "uno\n", 1, "t-", ErrNoStdin.Error()+"\n")
+	e = &Edlin{Stdout: ioutil.Discard, Lines: []string{"uno"}, Current: 1, Stdin: strings.NewReader("x\ny\nz\n")}
+	e.Exec("2t-:2")
+	assertLines(t, e, "uno\ny\n")
+	assertCurrent(t, e, 2)
+}
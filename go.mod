@@ -4,5 +4,5 @@ go 1.13
 
 require (
 	github.com/pkg/term v0.0.0-20190109203006-aa71e9d9e942
-	golang.org/x/sys v0.0.0-20190302025703-b6889370fb10 // indirect
+	golang.org/x/sys v0.0.0-20190302025703-b6889370fb10
 )
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/aarzilli/edlin/engine"
+)
+
+var TheEditor engine.Edlin
+
+func fatal(ctxt string, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", ctxt, err)
+		exit(1)
+	}
+}
+
+// exit releases the lock of the file being edited, so that it doesn't
+// outlive the session, and exits with code.
+func exit(code int) {
+	TheEditor.Unlock()
+	os.Exit(code)
+}
+
+// historyPath returns the path of the file where the command history is
+// saved: edlin/history in $XDG_STATE_HOME or in the user's configuration
+// directory. Returns an empty string if neither can be used.
+func historyPath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		var err error
+		dir, err = os.UserConfigDir()
+		if err != nil {
+			return ""
+		}
+	}
+	dir = filepath.Join(dir, "edlin")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "history")
+}
+
+// handleSignals makes SIGINT interrupt the current command and SIGTERM and
+// SIGHUP save the changes to the recovery file before exiting. In batch mode
+// SIGINT is treated like SIGTERM.
+func handleSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			if sig == os.Interrupt && !TheEditor.Batch {
+				TheEditor.Interrupt()
+				continue
+			}
+			// the editor stays blocked after EmergencySave, nothing
+			// changes while the process exits; Unlock doesn't wait
+			// for it
+			emergencySave(sig.String())
+			exit(1)
+		}
+	}()
+}
+
+// emergencySave restores the terminal and saves the changes to the recovery
+// file.
+func emergencySave(reason string) {
+	TheEditor.RestoreTerminal()
+	path, err := TheEditor.EmergencySave()
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "\n%s: could not save changes: %v\n", reason, err)
+	case path != "":
+		fmt.Fprintf(os.Stderr, "\n%s: changes saved to %s\n", reason, path)
+	}
+}
+
+// encodingNames returns the names of the known character encodings.
+func encodingNames() string {
+	names := make([]string, len(engine.Encodings))
+	for i, enc := range engine.Encodings {
+		names[i] = enc.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// load loads the file being edited, creating it if it doesn't exist.
+func load() {
+	if fh, err := os.Open(TheEditor.Path); err == nil {
+		fatal("read", TheEditor.Load(fh))
+	} else {
+		if !os.IsNotExist(err) {
+			fatal("open", err)
+		}
+		fh, err := os.Create(TheEditor.Path)
+		fatal("create", err)
+		fh.Close()
+		fmt.Printf("New file\n")
+	}
+}
+
+// lock locks the file being edited, if another session is editing it the
+// file is opened read-only, in batch mode edlin exits.
+func lock() {
+	err := TheEditor.Lock()
+	var lerr *engine.LockError
+	switch {
+	case err == nil:
+	case errors.As(err, &lerr):
+		if TheEditor.Batch {
+			fatal("lock", err)
+		}
+		fmt.Printf("%v, opening read-only\n", err)
+		TheEditor.ReadOnly = true
+	default:
+		// editing without a lock is better than not editing at all
+		fmt.Fprintf(os.Stderr, "lock: %v\n", err)
+	}
+}
+
+func main() {
+	script := flag.String("s", "", "read commands from `file` instead of the terminal")
+	encoding := flag.String("e", "", "character `encoding` of the file: "+encodingNames()+" (default: detected from the byte order mark or UTF-8)")
+	flag.BoolVar(&TheEditor.Binary, "b", false, "treat ^Z as ordinary data instead of the end of the file, like /B")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-b] [-s script] [-e encoding] file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "File name must be specified\n")
+		os.Exit(1)
+	}
+
+	term := engine.NewTermiosTerminal(os.Stdin, os.Stdout)
+	if *script != "" {
+		if !term.IsTerminal() {
+			// commands don't come from stdin, T- can read it
+			TheEditor.Stdin = os.Stdin
+		}
+		fh, err := os.Open(*script)
+		fatal("open script", err)
+		defer fh.Close()
+		term = engine.NewTermiosTerminal(fh, os.Stdout)
+	}
+	TheEditor.Term = term
+	TheEditor.Batch = !term.IsTerminal()
+	if !TheEditor.Batch {
+		TheEditor.HistoryFile = historyPath()
+	}
+
+	if *encoding != "" {
+		enc, ok := engine.LookupEncoding(*encoding)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown encoding %s, must be one of %s\n", *encoding, encodingNames())
+			os.Exit(1)
+		}
+		TheEditor.Encoding = enc
+	}
+
+	TheEditor.Path = flag.Arg(0)
+	lock()
+
+	// the recovery file of a read-only session would replace the one of
+	// the session holding the lock
+	TheEditor.Recover = !TheEditor.Batch && !TheEditor.ReadOnly
+
+	restored := false
+	if !TheEditor.ReadOnly {
+		var err error
+		restored, err = TheEditor.CheckRecover()
+		fatal("recover", err)
+	}
+
+	if !restored {
+		load()
+	}
+
+	TheEditor.Current = 1
+
+	handleSignals()
+	defer func() {
+		if ierr := recover(); ierr != nil {
+			emergencySave("panic")
+			TheEditor.Unlock()
+			panic(ierr)
+		}
+	}()
+
+	failed := false
+	for {
+		if !TheEditor.Batch {
+			fmt.Printf("*")
+		}
+		cmdstr, err := TheEditor.Input()
+		if err == engine.ErrInterrupted {
+			continue
+		}
+		if err == io.EOF {
+			// end of script without E or Q
+			if TheEditor.Dirty {
+				fmt.Fprintf(os.Stderr, "End of script, changes not saved\n")
+				exit(1)
+			}
+			break
+		}
+		fatal("read", err)
+
+		r, err := TheEditor.Exec(cmdstr)
+		if err != nil {
+			failed = true
+		}
+
+		if r == engine.Quit {
+			break
+		}
+	}
+
+	if TheEditor.Batch && failed {
+		exit(1)
+	}
+	TheEditor.Unlock()
+}